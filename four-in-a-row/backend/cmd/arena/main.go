@@ -0,0 +1,245 @@
+// Command arena plays two bots against each other and reports how they did.
+//
+//	go run ./backend/cmd/arena -a hard -b mcts -games 100 -random-plies 2
+//
+// A bot is any name the server accepts for the level query parameter
+// (easy, medium, hard, perfect, mcts), or server or services for the simple
+// BotMove functions in those packages. Colors alternate every game. With
+// -random-plies each opening is played twice, once with each bot moving
+// first, so neither side is favored by the draw. Each bot searches with
+// tables of its own, emptied before every game, so neither learns from the
+// other's search or from earlier games.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"time"
+
+	"player/backend/internal/bot"
+	"player/backend/internal/game"
+	"player/backend/internal/server"
+	"player/backend/internal/services"
+)
+
+// moveFunc adapts a BotMove style function to bot.Engine.
+type moveFunc struct {
+	name string
+	move func(g *game.Game, botPlayer int) int
+}
+
+func (m moveFunc) Name() string          { return m.name }
+func (m moveFunc) Move(g *game.Game) int { return m.move(g, g.Turn) }
+
+// newEngine returns the bot with a name, searching with the side's tables
+// if it is one of the levels.
+func newEngine(name string, s *side) (bot.Engine, error) {
+	switch name {
+	case "server":
+		return moveFunc{"server", server.BotMove}, nil
+	case "services":
+		return moveFunc{"services", services.BotMove}, nil
+	}
+	name, err := bot.ParseName(name)
+	if err != nil {
+		return nil, err
+	}
+	if l, err := bot.ParseLevel(name); err == nil {
+		return bot.LevelWithTables(l, s.search, s.solve), nil
+	}
+	return bot.ForName(name), nil
+}
+
+// side accumulates one bot's results from its own point of view.
+type side struct {
+	name                string
+	wins, draws, losses int
+	illegal             int
+	moves               int
+	thinking            time.Duration
+
+	search, solve *bot.Table // the bot's own, cleared before each game
+}
+
+func newSide(name string) *side {
+	return &side{name: name, search: bot.NewTable(bot.DefaultTableSize), solve: bot.NewTable(bot.DefaultTableSize)}
+}
+
+func main() {
+	a := flag.String("a", "hard", "first bot")
+	b := flag.String("b", "medium", "second bot")
+	games := flag.Int("games", 20, "games to play")
+	randomPlies := flag.Int("random-plies", 0, "random moves to open each pair of games with")
+	rows := flag.Int("rows", game.DefaultRows, "board rows")
+	cols := flag.Int("cols", game.DefaultCols, "board columns")
+	connect := flag.Int("connect", game.DefaultConnect, "discs in a line to win")
+	levels := flag.String("levels", os.Getenv("BOT_LEVELS"), "level overrides, as in BOT_LEVELS")
+	mcts := flag.String("mcts", os.Getenv("BOT_MCTS"), "mcts limits, as in BOT_MCTS")
+	book := flag.String("book", os.Getenv("BOT_BOOK"), "opening book for the levels, as in BOT_BOOK")
+	seed := flag.Int64("seed", time.Now().UnixNano(), "random seed for openings and bots")
+	flag.Parse()
+
+	rules := game.Rules{Rows: *rows, Cols: *cols, Connect: *connect}
+	if err := rules.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	if err := bot.ConfigureLevels(*levels); err != nil {
+		log.Fatal(err)
+	}
+	if *mcts != "" {
+		if err := bot.ConfigureMCTS(*mcts); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *book != "" {
+		bk, err := bot.LoadBook(*book)
+		if err != nil {
+			log.Fatal(err)
+		}
+		bot.SetBook(bot.BookConfig{Book: bk})
+	}
+	sides := [2]*side{newSide(*a), newSide(*b)}
+	for _, s := range sides {
+		if _, err := newEngine(s.name, s); err != nil {
+			log.Fatal(err)
+		}
+	}
+	rand.Seed(*seed)
+
+	var opening []int
+	for i := 0; i < *games; i++ {
+		if i%2 == 0 {
+			opening = randomOpening(rules, *randomPlies)
+		}
+		// sides[first] moves first; it alternates every game
+		first := i % 2
+		players := [2]*side{sides[first], sides[1-first]}
+		winner := play(rules, opening, players)
+		for p, s := range players {
+			switch {
+			case winner == 0:
+				s.draws++
+			case winner == p+1:
+				s.wins++
+			default:
+				s.losses++
+			}
+		}
+		result := "draw"
+		if winner != 0 {
+			result = players[winner-1].name + " wins"
+		}
+		log.Printf("game %d: %s (1) vs %s (2): %s", i+1, players[0].name, players[1].name, result)
+	}
+	report(sides)
+}
+
+// randomOpening plays plies random moves, retrying until the game is still
+// open afterwards.
+func randomOpening(rules game.Rules, plies int) []int {
+	for {
+		g, _ := game.NewGameWithRules(rules)
+		var moves []int
+		for len(moves) < plies && !g.Finished {
+			c := rand.Intn(rules.Cols)
+			if _, err := g.Drop(c, g.Turn); err == nil {
+				moves = append(moves, c)
+			}
+		}
+		if !g.Finished {
+			return moves
+		}
+	}
+}
+
+// play runs one game and returns the winning player, 0 for a draw. A bot
+// that picks an illegal column loses the game.
+func play(rules game.Rules, opening []int, players [2]*side) int {
+	g, _ := game.NewGameWithRules(rules)
+	for _, c := range opening {
+		g.Drop(c, g.Turn)
+	}
+	engines := [2]bot.Engine{}
+	for p, s := range players {
+		s.search.Clear()
+		s.solve.Clear()
+		engines[p], _ = newEngine(s.name, s)
+	}
+	for !g.Finished {
+		p := g.Turn - 1
+		start := time.Now()
+		// bots get a copy, so one that scribbles on the board cannot cheat
+		col := engines[p].Move(g.Clone())
+		players[p].thinking += time.Since(start)
+		players[p].moves++
+		if _, err := g.Drop(col, g.Turn); err != nil {
+			log.Printf("%s played column %d: %v", players[p].name, col, err)
+			players[p].illegal++
+			return 2 - p
+		}
+	}
+	return g.Winner
+}
+
+func report(sides [2]*side) {
+	a, b := sides[0], sides[1]
+	n := a.wins + a.draws + a.losses
+	fmt.Printf("\n%s vs %s, %d games\n", a.name, b.name, n)
+	fmt.Printf("%-10s W %d  D %d  L %d\n", a.name, a.wins, a.draws, a.losses)
+	diff, lo, hi := eloDiff(a.wins, a.draws, a.losses)
+	fmt.Printf("Elo difference: %s (95%% interval %s to %s)\n", elo(diff), elo(lo), elo(hi))
+	for _, s := range sides {
+		avg := time.Duration(0)
+		if s.moves > 0 {
+			avg = s.thinking / time.Duration(s.moves)
+		}
+		fmt.Printf("%-10s %d moves, average %v per move", s.name, s.moves, avg.Round(time.Microsecond))
+		if s.illegal > 0 {
+			fmt.Printf(", %d illegal", s.illegal)
+		}
+		fmt.Println()
+	}
+}
+
+// eloDiff estimates how much stronger the first player is from its score,
+// with a 95% interval from the spread of the individual game results.
+func eloDiff(wins, draws, losses int) (diff, lo, hi float64) {
+	n := float64(wins + draws + losses)
+	if n == 0 {
+		return math.NaN(), math.NaN(), math.NaN()
+	}
+	score := (float64(wins) + float64(draws)/2) / n
+	variance := (float64(wins)*math.Pow(1-score, 2) +
+		float64(draws)*math.Pow(0.5-score, 2) +
+		float64(losses)*math.Pow(score, 2)) / n
+	margin := 1.96 * math.Sqrt(variance/n)
+	return eloOf(score), eloOf(score - margin), eloOf(score + margin)
+}
+
+func eloOf(score float64) float64 {
+	switch {
+	case score <= 0:
+		return math.Inf(-1)
+	case score >= 1:
+		return math.Inf(1)
+	}
+	return -400 * math.Log10(1/score-1)
+}
+
+func elo(x float64) string {
+	switch {
+	case math.IsNaN(x):
+		return "n/a"
+	case math.IsInf(x, 1):
+		return "+inf"
+	case math.IsInf(x, -1):
+		return "-inf"
+	case math.Abs(x) < 0.5:
+		return "0"
+	}
+	return fmt.Sprintf("%+.0f", x)
+}
@@ -0,0 +1,226 @@
+// Command book builds an opening book for the bots, either by solving every
+// position of the first few plies or from the games stored in Postgres.
+//
+//	go run ./backend/cmd/book -source solver -plies 4 -budget 30s -out book.txt
+//	go run ./backend/cmd/book -source games -plies 8 -min-games 5 -out book.txt
+//
+// Solving early positions of the full board can take minutes each, so with
+// -update an existing book is kept and only the positions it is missing are
+// solved; the book is saved as it grows, and an interrupted run can be
+// resumed the same way. Serve the result with BOT_BOOK=book.txt.
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"time"
+
+	"player/backend/internal/bot"
+	"player/backend/internal/game"
+	"player/backend/internal/server"
+)
+
+func main() {
+	source := flag.String("source", "solver", "where scores come from: solver or games")
+	out := flag.String("out", "book.txt", "book file to write")
+	plies := flag.Int("plies", 4, "cover positions with fewer discs than this")
+	rows := flag.Int("rows", game.DefaultRows, "board rows")
+	cols := flag.Int("cols", game.DefaultCols, "board columns")
+	connect := flag.Int("connect", game.DefaultConnect, "discs in a line to win")
+	budget := flag.Duration("budget", 30*time.Second, "solver: time allowed per position")
+	update := flag.Bool("update", false, "solver: keep the positions already in -out")
+	dsn := flag.String("dsn", os.Getenv("PG_DSN"), "games: Postgres DSN")
+	minGames := flag.Int("min-games", 3, "games: fewest games a move needs to be scored")
+	flag.Parse()
+
+	rules := game.Rules{Rows: *rows, Cols: *cols, Connect: *connect}
+	if err := rules.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	var err error
+	switch *source {
+	case "solver":
+		err = fromSolver(rules, *out, *plies, *budget, *update)
+	case "games":
+		err = fromGames(rules, *out, *plies, *dsn, *minGames)
+	default:
+		err = errors.New("-source must be solver or games")
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// fromSolver walks every position up to plies breadth first, skipping
+// mirror images, and scores the columns the solver can settle in time.
+func fromSolver(rules game.Rules, out string, plies int, budget time.Duration, update bool) error {
+	if !game.Fits(rules) {
+		return game.ErrBoardTooLarge
+	}
+	book := bot.NewBook(rules, "solver")
+	if update {
+		b, err := bot.LoadBook(out)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+		case err != nil:
+			return err
+		case b.Rules != rules:
+			return errors.New("existing book was built for other rules")
+		default:
+			book = b
+		}
+	}
+	solver := bot.NewSolver(budget)
+	seen := map[uint64]bool{}
+	queue := [][]int{{}}
+	solved := 0
+	for len(queue) > 0 {
+		moves := queue[0]
+		queue = queue[1:]
+		g, err := (&game.Record{Result: game.ResultOngoing, Rules: rules, Columns: moves}).Game()
+		if err != nil {
+			return err
+		}
+		if g.Finished || seen[g.Hash()] || seen[g.Mirror().Hash()] {
+			continue
+		}
+		seen[g.Hash()] = true
+		if !book.Has(g) {
+			scores, err := solve(solver, g)
+			if err != nil {
+				return err
+			}
+			if len(scores) > 0 {
+				if err := book.Add(moves, scores); err != nil {
+					return err
+				}
+			}
+			solved++
+			log.Printf("%v: %d columns solved, %d positions in book", moves, len(scores), book.Len())
+			if solved%20 == 0 {
+				if err := bot.SaveBook(out, book); err != nil {
+					return err
+				}
+			}
+		}
+		if len(moves)+1 >= plies {
+			continue
+		}
+		for c := 0; c < rules.Cols; c++ {
+			if g.NextRow(c) >= 0 {
+				queue = append(queue, append(append([]int(nil), moves...), c))
+			}
+		}
+	}
+	log.Printf("%d positions in book", book.Len())
+	return bot.SaveBook(out, book)
+}
+
+func solve(s *bot.Solver, g *game.Game) ([]bot.BookMove, error) {
+	a, err := s.Analyze(g)
+	if err != nil {
+		return nil, err
+	}
+	var scores []bot.BookMove
+	for _, c := range a.Columns {
+		if c.Value != nil {
+			scores = append(scores, bot.BookMove{Column: c.Column, Score: c.Value.Score})
+		}
+	}
+	return scores, nil
+}
+
+// tally counts how the games that reached a position went, per column.
+type tally struct {
+	moves []int
+	games map[int]*[2]int // column -> games played, mover's wins minus losses
+}
+
+// fromGames scores each move of the first plies by how the stored games
+// that played it ended for the player who made it.
+func fromGames(rules game.Rules, out string, plies int, dsn string, minGames int) error {
+	if dsn == "" {
+		return errors.New("-dsn or PG_DSN is required for -source games")
+	}
+	pg, err := server.NewPGStore(dsn)
+	if err != nil {
+		return err
+	}
+	positions := map[uint64]*tally{}
+	used := 0
+	err = pg.EachGame(func(rec *server.GameRecord) error {
+		// imported games are unchecked claims, not games played here
+		if rec.Rules != rules || rec.Imported {
+			return nil
+		}
+		g, _ := game.NewGameWithRules(rules)
+		var moves []int
+		for i, m := range rec.Moves {
+			if i >= plies {
+				break
+			}
+			// count mirror images together, under whichever was seen first
+			key, col, seq := g.Hash(), m.Column, moves
+			if _, ok := positions[key]; !ok {
+				if mk := g.Mirror().Hash(); positions[mk] != nil {
+					key, col, seq = mk, rules.Cols-1-col, mirror(rules, moves)
+				}
+			}
+			if _, err := g.Drop(m.Column, m.Player); err != nil {
+				log.Printf("game %s: move %d: %v", rec.ID, i+1, err)
+				return nil
+			}
+			t := positions[key]
+			if t == nil {
+				t = &tally{moves: append([]int(nil), seq...), games: map[int]*[2]int{}}
+				positions[key] = t
+			}
+			n := t.games[col]
+			if n == nil {
+				n = &[2]int{}
+				t.games[col] = n
+			}
+			n[0]++
+			switch rec.Winner {
+			case m.Player:
+				n[1]++
+			case 0:
+			default:
+				n[1]--
+			}
+			moves = append(moves, m.Column)
+		}
+		used++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	book := bot.NewBook(rules, "games")
+	for _, t := range positions {
+		var scores []bot.BookMove
+		for col, n := range t.games {
+			if n[0] >= minGames {
+				scores = append(scores, bot.BookMove{Column: col, Score: 100 * n[1] / n[0]})
+			}
+		}
+		if len(scores) == 0 {
+			continue
+		}
+		if err := book.Add(t.moves, scores); err != nil {
+			return err
+		}
+	}
+	log.Printf("%d games read, %d positions in book", used, book.Len())
+	return bot.SaveBook(out, book)
+}
+
+func mirror(rules game.Rules, moves []int) []int {
+	m := make([]int, len(moves))
+	for i, c := range moves {
+		m[i] = rules.Cols - 1 - c
+	}
+	return m
+}
@@ -1,11 +1,17 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
+	"player/backend/internal/bot"
 	"player/backend/internal/routes"
 	"player/backend/internal/server"
+	"strconv"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -13,8 +19,14 @@ import (
 func main() {
 	router := gin.Default()
 
+	// Ctrl-C or SIGTERM stops matchmaking, telling those still waiting,
+	// and then the server
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	mgr := server.NewManager()
 	mm := server.NewMatchmaker()
+	go mm.Run(ctx)
 
 	// Postgres config
 	pgdsn := os.Getenv("PG_DSN")
@@ -40,7 +52,47 @@ func main() {
 	}
 	kprod := server.NewKafkaProducer(brokers, topic)
 
+	// Bot difficulty overrides, e.g. BOT_LEVELS="hard=12/2s,perfect=0/5s"
+	if spec := os.Getenv("BOT_LEVELS"); spec != "" {
+		if err := bot.ConfigureLevels(spec); err != nil {
+			panic("Invalid BOT_LEVELS: " + err.Error())
+		}
+	}
+
+	// Monte Carlo personality limits, e.g. BOT_MCTS="20000" or "0/2s"
+	if spec := os.Getenv("BOT_MCTS"); spec != "" {
+		if err := bot.ConfigureMCTS(spec); err != nil {
+			panic("Invalid BOT_MCTS: " + err.Error())
+		}
+	}
+
+	// Opening book, e.g. BOT_BOOK=book.txt BOT_BOOK_PLIES=8 BOT_BOOK_RANDOMNESS=0.3
+	if path := os.Getenv("BOT_BOOK"); path != "" {
+		book, err := bot.LoadBook(path)
+		if err != nil {
+			panic("Failed to load BOT_BOOK: " + err.Error())
+		}
+		cfg := bot.BookConfig{Book: book}
+		if v := os.Getenv("BOT_BOOK_PLIES"); v != "" {
+			if cfg.Plies, err = strconv.Atoi(v); err != nil {
+				panic("Invalid BOT_BOOK_PLIES: " + err.Error())
+			}
+		}
+		if v := os.Getenv("BOT_BOOK_RANDOMNESS"); v != "" {
+			if cfg.Randomness, err = strconv.ParseFloat(v, 64); err != nil {
+				panic("Invalid BOT_BOOK_RANDOMNESS: " + err.Error())
+			}
+		}
+		bot.SetBook(cfg)
+		fmt.Printf("Opening book %s: %d positions\n", path, book.Len())
+	}
+
 	ws := server.NewWSHandler(mgr, mm, pgstore, kprod)
+	// Key for signing resume tokens; without it they are signed with a
+	// random key and do not survive a restart
+	if secret := os.Getenv("TOKEN_SECRET"); secret != "" {
+		ws.SetSecret([]byte(secret))
+	}
 
 	router.GET("/", func(c *gin.Context) {
 		c.String(http.StatusOK, "4 in a Row backend is running 🚀")
@@ -50,8 +102,17 @@ func main() {
 	// serve static frontend if present
 	router.Static("/static", "./static")
 	// Register correct leaderboard route
-	routes.RegisterRoutes(router, pgstore)
+	routes.RegisterRoutes(router, pgstore, ws)
 
+	srv := &http.Server{Addr: ":8080", Handler: router}
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdown)
+	}()
 	fmt.Println("Server running on http://localhost:8080")
-	router.Run(":8080")
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		panic("Server failed: " + err.Error())
+	}
 }
@@ -0,0 +1,352 @@
+package bot
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"player/backend/internal/game"
+)
+
+// Opening book
+//
+// A book is a text file that starts with header tags, in the same form as
+// game notation, followed by one line per position: the moves that lead to
+// it in notation form ("-" for the empty board), a tab, then the known
+// columns with their scores for the player to move.
+//
+//	[Rows "6"]
+//	[Cols "7"]
+//	[Connect "4"]
+//	[Source "solver"]
+//
+//	-	4:1 3:0 5:0 2:-1 6:-1 1:-2 7:-2
+//	4	4:-1 3:-1 5:-1
+//
+// Columns are 1-based and higher scores are better. A solver book holds
+// solver scores; a book built from played games holds, per column, the
+// mover's wins minus losses as a percentage of the games that went there.
+// In memory, positions are keyed by their Zobrist hash so that move orders
+// reaching the same board share an entry, and a position missing from the
+// book is looked up mirrored as well.
+
+var ErrBadBook = errors.New("bad opening book")
+
+// BookMove is a column known to the book, with its score for the mover.
+type BookMove struct {
+	Column int
+	Score  int
+}
+
+type bookEntry struct {
+	moves []int
+	best  []BookMove // sorted, best first
+}
+
+// Book maps opening positions to scored moves.
+type Book struct {
+	Rules   game.Rules
+	Source  string
+	entries map[uint64]*bookEntry
+}
+
+func NewBook(rules game.Rules, source string) *Book {
+	return &Book{Rules: rules, Source: source, entries: map[uint64]*bookEntry{}}
+}
+
+// Len returns the number of positions in the book.
+func (b *Book) Len() int {
+	return len(b.entries)
+}
+
+// Add records the scored moves for the position reached by playing moves,
+// replacing what the book knew about it.
+func (b *Book) Add(moves []int, scores []BookMove) error {
+	g, err := (&game.Record{Result: game.ResultOngoing, Rules: b.Rules, Columns: moves}).Game()
+	if err != nil {
+		return err
+	}
+	if g.Finished {
+		return fmt.Errorf("%w: game is over after %v", ErrBadBook, moves)
+	}
+	for _, m := range scores {
+		if g.NextRow(m.Column) < 0 {
+			return fmt.Errorf("%w: column %d cannot be played after %v", ErrBadBook, m.Column+1, moves)
+		}
+	}
+	best := append([]BookMove(nil), scores...)
+	sort.SliceStable(best, func(i, j int) bool { return best[i].Score > best[j].Score })
+	b.entries[g.Hash()] = &bookEntry{moves: append([]int(nil), moves...), best: best}
+	return nil
+}
+
+// Has reports whether the book knows g or its mirror image.
+func (b *Book) Has(g *game.Game) bool {
+	_, ok := b.Lookup(g)
+	return ok
+}
+
+// Lookup returns the book moves for g, best first.
+func (b *Book) Lookup(g *game.Game) ([]BookMove, bool) {
+	if g.Rules != b.Rules {
+		return nil, false
+	}
+	if e, ok := b.entries[g.Hash()]; ok && len(e.best) > 0 {
+		return e.best, true
+	}
+	e, ok := b.entries[g.Mirror().Hash()]
+	if !ok || len(e.best) == 0 {
+		return nil, false
+	}
+	last := g.Rules.Cols - 1
+	moves := make([]BookMove, len(e.best))
+	for i, m := range e.best {
+		moves[i] = BookMove{Column: last - m.Column, Score: m.Score}
+	}
+	return moves, true
+}
+
+// Pick chooses a book move for g. With randomness 0 it plays the best
+// score, picking at random between equal ones; otherwise, with probability
+// randomness, it plays any move whose score has the same sign as the best,
+// so a won position stays won and a drawn one is not thrown away.
+func (b *Book) Pick(g *game.Game, randomness float64) (int, bool) {
+	moves, ok := b.Lookup(g)
+	if !ok {
+		return 0, false
+	}
+	best := moves[0].Score
+	n := 1
+	if randomness > 0 && rand.Float64() < randomness {
+		for n < len(moves) && sign(moves[n].Score) == sign(best) {
+			n++
+		}
+	} else {
+		for n < len(moves) && moves[n].Score == best {
+			n++
+		}
+	}
+	return moves[rand.Intn(n)].Column, true
+}
+
+func sign(x int) int {
+	switch {
+	case x > 0:
+		return 1
+	case x < 0:
+		return -1
+	}
+	return 0
+}
+
+// Encode writes the book, shortest move sequences first.
+func (b *Book) Encode(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "[Rows %q]\n[Cols %q]\n[Connect %q]\n", strconv.Itoa(b.Rules.Rows), strconv.Itoa(b.Rules.Cols), strconv.Itoa(b.Rules.Connect))
+	if b.Source != "" {
+		fmt.Fprintf(bw, "[Source %q]\n", b.Source)
+	}
+	bw.WriteString("\n")
+	lines := make([]string, 0, len(b.entries))
+	for _, e := range b.entries {
+		var line strings.Builder
+		line.WriteString(b.formatMoves(e.moves))
+		line.WriteString("\t")
+		for i, m := range e.best {
+			if i > 0 {
+				line.WriteString(" ")
+			}
+			fmt.Fprintf(&line, "%d:%d", m.Column+1, m.Score)
+		}
+		lines = append(lines, line.String())
+	}
+	sort.Slice(lines, func(i, j int) bool {
+		if a, b := strings.IndexByte(lines[i], '\t'), strings.IndexByte(lines[j], '\t'); a != b {
+			return a < b
+		}
+		return lines[i] < lines[j]
+	})
+	for _, l := range lines {
+		bw.WriteString(l)
+		bw.WriteString("\n")
+	}
+	return bw.Flush()
+}
+
+func (b *Book) formatMoves(moves []int) string {
+	if len(moves) == 0 {
+		return "-"
+	}
+	sep := ""
+	if b.Rules.Cols > 9 {
+		sep = " "
+	}
+	s := make([]string, len(moves))
+	for i, c := range moves {
+		s[i] = strconv.Itoa(c + 1)
+	}
+	return strings.Join(s, sep)
+}
+
+// ReadBook parses a book written by Encode.
+func ReadBook(r io.Reader) (*Book, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+	var header []string
+	var b *Book
+	start := func() error {
+		rec, err := game.ParseRecord(strings.Join(header, "\n"))
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrBadBook, err)
+		}
+		b = NewBook(rec.Rules, bookSource(header))
+		return nil
+	}
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if b == nil {
+			if strings.HasPrefix(line, "[") {
+				header = append(header, line)
+				continue
+			}
+			if err := start(); err != nil {
+				return nil, err
+			}
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// keep the tab of a position with no moves scored yet
+		if err := b.addLine(strings.TrimRight(sc.Text(), "\r")); err != nil {
+			return nil, fmt.Errorf("%w: line %d: %v", ErrBadBook, n, err)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if b == nil {
+		if err := start(); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+func bookSource(header []string) string {
+	for _, h := range header {
+		if v, ok := strings.CutPrefix(h, "[Source "); ok {
+			s, err := strconv.Unquote(strings.TrimSuffix(v, "]"))
+			if err == nil {
+				return s
+			}
+		}
+	}
+	return ""
+}
+
+func (b *Book) addLine(line string) error {
+	seq, scores, ok := strings.Cut(line, "\t")
+	if !ok {
+		return errors.New("want moves, a tab, then column:score pairs")
+	}
+	var moves []int
+	if seq = strings.TrimSpace(seq); seq != "-" {
+		var err error
+		if moves, err = game.ParseMoves(b.Rules, seq); err != nil {
+			return err
+		}
+	}
+	var best []BookMove
+	for _, f := range strings.Fields(scores) {
+		col, score, ok := strings.Cut(f, ":")
+		c, err1 := strconv.Atoi(col)
+		s, err2 := strconv.Atoi(score)
+		if !ok || err1 != nil || err2 != nil || c < 1 || c > b.Rules.Cols {
+			return fmt.Errorf("bad move score %q", f)
+		}
+		best = append(best, BookMove{Column: c - 1, Score: s})
+	}
+	return b.Add(moves, best)
+}
+
+// LoadBook reads a book from a file.
+func LoadBook(path string) (*Book, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadBook(f)
+}
+
+// SaveBook writes a book to a file, replacing it.
+func SaveBook(path string, b *Book) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := b.Encode(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// BookEngine plays from a book for the first Plies discs of a game and
+// leaves the rest, and any position the book does not know, to Engine.
+type BookEngine struct {
+	Book       *Book
+	Plies      int     // 0 to play from the book wherever it knows the position
+	Randomness float64 // 0 always plays the best book move, see Book.Pick
+	Engine     Engine
+}
+
+func (e *BookEngine) Name() string {
+	return e.Engine.Name()
+}
+
+func (e *BookEngine) Move(g *game.Game) int {
+	plies := g.Rules.Rows*g.Rules.Cols - emptyCells(g)
+	if e.Plies == 0 || plies < e.Plies {
+		if col, ok := e.Book.Pick(g, e.Randomness); ok {
+			return col
+		}
+	}
+	return e.Engine.Move(g)
+}
+
+// BookConfig is the book the engines from ForLevel open with.
+type BookConfig struct {
+	Book       *Book
+	Plies      int
+	Randomness float64
+}
+
+var (
+	bookMu     sync.RWMutex
+	openingCfg BookConfig
+)
+
+// SetBook makes ForLevel engines play from a book; a nil Book turns it off.
+func SetBook(cfg BookConfig) {
+	bookMu.Lock()
+	defer bookMu.Unlock()
+	openingCfg = cfg
+}
+
+func openingBook() BookConfig {
+	bookMu.RLock()
+	defer bookMu.RUnlock()
+	return openingCfg
+}
@@ -0,0 +1,286 @@
+package bot
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"player/backend/internal/game"
+)
+
+// Engine chooses a column for the player whose turn it is.
+type Engine interface {
+	Name() string
+	Move(g *game.Game) int
+}
+
+// Level is a difficulty players can pick when they end up against the bot.
+type Level string
+
+const (
+	Easy    Level = "easy"
+	Medium  Level = "medium"
+	Hard    Level = "hard"
+	Perfect Level = "perfect"
+
+	DefaultLevel = Medium
+)
+
+// LevelConfig controls how much a level is allowed to search.
+type LevelConfig struct {
+	Depth  int           // maximum plies to look ahead, 0 for no limit
+	Budget time.Duration // time allowed per move, 0 for no limit
+}
+
+var (
+	levelsMu sync.RWMutex
+	levels   = map[Level]LevelConfig{
+		Easy:    {Depth: 2},
+		Medium:  {Depth: 4},
+		Hard:    {Depth: 10, Budget: time.Second},
+		Perfect: {Depth: 0, Budget: 3 * time.Second},
+	}
+)
+
+// Levels returns the known difficulty levels, easiest first.
+func Levels() []Level {
+	return []Level{Easy, Medium, Hard, Perfect}
+}
+
+// ParseLevel accepts a level name; an empty name means DefaultLevel.
+func ParseLevel(s string) (Level, error) {
+	if s == "" {
+		return DefaultLevel, nil
+	}
+	l := Level(strings.ToLower(s))
+	levelsMu.RLock()
+	_, ok := levels[l]
+	levelsMu.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("unknown bot level %q", s)
+	}
+	return l, nil
+}
+
+// Config returns the search limits currently used for a level.
+func Config(l Level) LevelConfig {
+	levelsMu.RLock()
+	defer levelsMu.RUnlock()
+	return levels[l]
+}
+
+// SetConfig changes the search limits for a level.
+func SetConfig(l Level, cfg LevelConfig) {
+	levelsMu.Lock()
+	defer levelsMu.Unlock()
+	levels[l] = cfg
+}
+
+// ConfigureLevels applies overrides written as a comma separated list of
+// level=depth[/budget], e.g. "hard=12/2s,perfect=0/5s". A depth of 0 has no
+// limit, so it needs a budget: a search of the whole board would never end.
+func ConfigureLevels(spec string) error {
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return fmt.Errorf("bot level %q: want level=depth[/budget]", part)
+		}
+		l, err := ParseLevel(name)
+		if err != nil {
+			return err
+		}
+		depth, budget, _ := strings.Cut(value, "/")
+		var cfg LevelConfig
+		if cfg.Depth, err = strconv.Atoi(depth); err != nil || cfg.Depth < 0 {
+			return fmt.Errorf("bot level %s: bad depth %q", l, depth)
+		}
+		if budget != "" {
+			if cfg.Budget, err = time.ParseDuration(budget); err != nil || cfg.Budget < 0 {
+				return fmt.Errorf("bot level %s: bad budget %q", l, budget)
+			}
+		}
+		if cfg.Depth == 0 && cfg.Budget == 0 {
+			return fmt.Errorf("bot level %s: depth 0 needs a budget", l)
+		}
+		SetConfig(l, cfg)
+	}
+	return nil
+}
+
+// ForLevel returns the engine that plays at the given level, searching with
+// the level's own table. Perfect tries to solve the position with half of
+// its budget and searches with the rest when it cannot. Every level opens
+// from the book set with SetBook, if any.
+func ForLevel(l Level) Engine {
+	return LevelWithTables(l, TableFor(string(l)), SolverTable)
+}
+
+// LevelWithTables is ForLevel with the tables to search and solve with given
+// by the caller, so the engine neither uses nor feeds anybody else's.
+func LevelWithTables(l Level, search, solve *Table) Engine {
+	cfg := Config(l)
+	m := &Minimax{Label: string(l), Depth: cfg.Depth, Budget: cfg.Budget, Table: search}
+	var e Engine = m
+	if l == Perfect {
+		m.Budget /= 2
+		e = &Solver{Label: string(l), Budget: cfg.Budget / 2, Table: solve, Fallback: m}
+	}
+	if book := openingBook(); book.Book != nil {
+		e = &BookEngine{Book: book.Book, Plies: book.Plies, Randomness: book.Randomness, Engine: e}
+	}
+	return e
+}
+
+// MCTSConfig controls how long the mcts personality thinks.
+type MCTSConfig struct {
+	Iterations int           // playouts per move, 0 for no limit
+	Budget     time.Duration // time per move, 0 for no limit
+}
+
+// Personalities are bots picked by name rather than by difficulty. Unlike
+// the levels they may answer the same position differently from one game
+// to the next.
+var (
+	mctsConfig    = MCTSConfig{Budget: time.Second}
+	personalities = map[string]func() Engine{
+		"mcts": func() Engine {
+			levelsMu.RLock()
+			defer levelsMu.RUnlock()
+			return &MCTS{Label: "mcts", Iterations: mctsConfig.Iterations, Budget: mctsConfig.Budget}
+		},
+	}
+)
+
+// Names lists every bot that can be asked for by name: the levels, easiest
+// first, then the personalities.
+func Names() []string {
+	var names []string
+	for _, l := range Levels() {
+		names = append(names, string(l))
+	}
+	var extra []string
+	for name := range personalities {
+		extra = append(extra, name)
+	}
+	sort.Strings(extra)
+	return append(names, extra...)
+}
+
+// ParseName accepts a level or personality name; an empty name means
+// DefaultLevel.
+func ParseName(s string) (string, error) {
+	if _, ok := personalities[strings.ToLower(s)]; ok {
+		return strings.ToLower(s), nil
+	}
+	l, err := ParseLevel(s)
+	if err != nil {
+		return "", fmt.Errorf("unknown bot %q", s)
+	}
+	return string(l), nil
+}
+
+// ForName returns a new engine for a name accepted by ParseName, or the
+// default level for any other name.
+func ForName(name string) Engine {
+	if newEngine, ok := personalities[name]; ok {
+		return newEngine()
+	}
+	l, err := ParseLevel(name)
+	if err != nil {
+		l = DefaultLevel
+	}
+	return ForLevel(l)
+}
+
+// ConfigureMCTS sets the limits of the mcts personality, written as
+// iterations[/budget], e.g. "20000" or "0/2s".
+func ConfigureMCTS(spec string) error {
+	iterations, budget, _ := strings.Cut(strings.TrimSpace(spec), "/")
+	var cfg MCTSConfig
+	var err error
+	if cfg.Iterations, err = strconv.Atoi(iterations); err != nil || cfg.Iterations < 0 {
+		return fmt.Errorf("mcts: bad iterations %q", iterations)
+	}
+	if budget != "" {
+		if cfg.Budget, err = time.ParseDuration(budget); err != nil {
+			return fmt.Errorf("mcts: %v", err)
+		}
+	}
+	levelsMu.Lock()
+	defer levelsMu.Unlock()
+	mctsConfig = cfg
+	return nil
+}
+
+// WithBudget returns e limited to thinking for at most d per move, for
+// playing on a clock. Engines with no time limit of their own are returned
+// as they are.
+func WithBudget(e Engine, d time.Duration) Engine {
+	switch e := e.(type) {
+	case *Minimax:
+		m := *e
+		m.Budget = limit(m.Budget, d)
+		return &m
+	case *MCTS:
+		m := *e
+		m.Budget = limit(m.Budget, d)
+		return &m
+	case *Solver:
+		// the solver and its fallback can both run on one move
+		s := *e
+		s.Budget = limit(s.Budget, d/2)
+		if s.Fallback != nil {
+			s.Fallback = WithBudget(s.Fallback, d/2)
+		}
+		return &s
+	case *BookEngine:
+		b := *e
+		b.Engine = WithBudget(b.Engine, d)
+		return &b
+	}
+	return e
+}
+
+// limit returns the shorter of a budget and d, where a zero budget has no
+// limit.
+func limit(budget, d time.Duration) time.Duration {
+	if budget == 0 || budget > d {
+		return d
+	}
+	return budget
+}
+
+// CenterOrder lists columns from the middle outwards, e.g. 3,2,4,1,5,0,6
+// for seven columns, which is the order moves are usually best tried in.
+func CenterOrder(cols int) []int {
+	order := make([]int, cols)
+	for i := range order {
+		order[i] = i
+	}
+	mid := float64(cols-1) / 2
+	sort.SliceStable(order, func(i, j int) bool {
+		return abs(float64(order[i])-mid) < abs(float64(order[j])-mid)
+	})
+	return order
+}
+
+func abs(x float64) float64 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func opponent(p int) int {
+	if p == 1 {
+		return 2
+	}
+	return 1
+}
@@ -0,0 +1,40 @@
+package bot
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConfigureLevels(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    LevelConfig
+		wantErr bool
+	}{
+		{"hard=12/2s", LevelConfig{Depth: 12, Budget: 2 * time.Second}, false},
+		{"hard=6", LevelConfig{Depth: 6}, false},
+		{"hard=0/5s", LevelConfig{Budget: 5 * time.Second}, false},
+		{"hard=0", LevelConfig{}, true},
+		{"hard=0/0s", LevelConfig{}, true},
+		{"hard=4/-1s", LevelConfig{}, true},
+		{"hard=-1", LevelConfig{}, true},
+		{"hard", LevelConfig{}, true},
+	}
+	saved := Config(Hard)
+	defer SetConfig(Hard, saved)
+	for _, tt := range tests {
+		SetConfig(Hard, saved)
+		err := ConfigureLevels(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ConfigureLevels(%q) error = %v, want error %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			if got := Config(Hard); got != saved {
+				t.Errorf("ConfigureLevels(%q) failed but set %+v", tt.spec, got)
+			}
+		} else if got := Config(Hard); got != tt.want {
+			t.Errorf("ConfigureLevels(%q) set %+v, want %+v", tt.spec, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package bot
+
+import "player/backend/internal/game"
+
+// WinScore is the value of a won position. Wins found sooner score higher,
+// so anything above WinScore-MaxPly is a forced win.
+const (
+	WinScore = 1_000_000
+	MaxPly   = game.MaxSize * game.MaxSize
+)
+
+// Evaluate scores a position from player's point of view without searching.
+// Every line of Rules.Connect cells that only one side occupies is worth
+// more the fuller it is; discs in the center columns earn a small bonus.
+func Evaluate(g *game.Game, player int) int {
+	rows, cols, n := g.Rules.Rows, g.Rules.Cols, g.Rules.Connect
+	score := 0
+	dirs := [][2]int{{0, 1}, {1, 0}, {1, 1}, {1, -1}}
+	for r := 0; r < rows; r++ {
+		for c := 0; c < cols; c++ {
+			for _, d := range dirs {
+				er, ec := r+d[0]*(n-1), c+d[1]*(n-1)
+				if er < 0 || er >= rows || ec < 0 || ec >= cols {
+					continue
+				}
+				mine, theirs := 0, 0
+				for i := 0; i < n; i++ {
+					switch g.Board[r+d[0]*i][c+d[1]*i] {
+					case 0:
+					case player:
+						mine++
+					default:
+						theirs++
+					}
+				}
+				switch {
+				case theirs == 0:
+					score += lineWeight(mine, n)
+				case mine == 0:
+					score -= lineWeight(theirs, n)
+				}
+			}
+		}
+	}
+	// center control
+	for _, c := range centerColumns(cols) {
+		for r := 0; r < rows; r++ {
+			switch g.Board[r][c] {
+			case 0:
+			case player:
+				score += 3
+			default:
+				score -= 3
+			}
+		}
+	}
+	return score
+}
+
+func lineWeight(discs, n int) int {
+	switch {
+	case discs == 0:
+		return 0
+	case discs >= n-1:
+		return 50
+	case discs == n-2:
+		return 10
+	default:
+		return 1
+	}
+}
+
+// centerColumns returns the middle column, or the middle two on even boards.
+func centerColumns(cols int) []int {
+	if cols%2 == 1 {
+		return []int{cols / 2}
+	}
+	return []int{cols/2 - 1, cols / 2}
+}
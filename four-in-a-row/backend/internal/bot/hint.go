@@ -0,0 +1,78 @@
+package bot
+
+import (
+	"time"
+
+	"player/backend/internal/game"
+)
+
+// Reasons a hint gives for its column.
+const (
+	ReasonWin   = "winning_move"
+	ReasonBlock = "forced_block"
+	ReasonBest  = "best_evaluation"
+)
+
+// Hint is a suggested column for the player to move, and why.
+type Hint struct {
+	Column int    `json:"column"`
+	Reason string `json:"reason"`
+}
+
+// hintSearch is the engine behind ReasonBest hints; it is about as strong
+// as the hard level so a hint is worth asking for.
+var hintSearch = &Minimax{Label: "hint", Depth: 10, Budget: 500 * time.Millisecond, Table: TableFor("hint")}
+
+// Suggest returns a hint for the player to move: a column that wins at
+// once, else one that stops the opponent winning next move, else the best
+// column a search can find.
+func Suggest(g *game.Game) (Hint, error) {
+	if g.Finished {
+		return Hint{}, ErrGameOver
+	}
+	legal := legalColumns(g)
+	for _, c := range legal {
+		if winsAt(g, c, g.Turn) {
+			return Hint{Column: c, Reason: ReasonWin}, nil
+		}
+	}
+	for _, c := range legal {
+		if winsAt(g, c, opponent(g.Turn)) {
+			return Hint{Column: c, Reason: ReasonBlock}, nil
+		}
+	}
+	col, _ := hintSearch.Search(g)
+	return Hint{Column: col, Reason: ReasonBest}, nil
+}
+
+// Evaluation returns how good g looks for player after a short search,
+// positive when player is ahead.
+func Evaluation(g *game.Game, player int) int {
+	if g.Finished {
+		switch g.Winner {
+		case 0:
+			return 0
+		case player:
+			return WinScore
+		}
+		return -WinScore
+	}
+	_, score := hintSearch.Search(g)
+	if g.Turn != player {
+		score = -score
+	}
+	return score
+}
+
+// AcceptsDraw reports whether a bot playing as player agrees to a draw in
+// g: only when it does not think it is ahead.
+func AcceptsDraw(g *game.Game, player int) bool {
+	return Evaluation(g, player) <= 0
+}
+
+// AcceptsTakeback reports whether a bot playing as player lets the
+// opponent take back their move: it does unless it has already found a
+// forced win.
+func AcceptsTakeback(g *game.Game, player int) bool {
+	return Evaluation(g, player) <= WinScore-MaxPly
+}
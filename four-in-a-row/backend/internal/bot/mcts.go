@@ -0,0 +1,158 @@
+package bot
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	"player/backend/internal/game"
+)
+
+// MCTS is a Monte Carlo tree search: it plays random games from the current
+// position, grows a tree towards the moves that win most often and picks
+// the column that was explored the most. It plays on game.Game itself, so
+// it handles every board size, and since the playouts are random it does not
+// always answer a position the same way.
+type MCTS struct {
+	Label       string
+	Iterations  int           // playouts per move, 0 for no limit
+	Budget      time.Duration // time per move, 0 for no limit
+	Exploration float64       // UCT constant, 0 for the usual sqrt(2)
+}
+
+// defaultIterations bounds an MCTS that was given neither limit.
+const defaultIterations = 10000
+
+type mctsNode struct {
+	parent   *mctsNode
+	column   int
+	player   int // who played column to reach this node
+	children []*mctsNode
+	untried  []int
+	visits   int
+	wins     float64 // for player, draws count half
+}
+
+func (m *MCTS) Name() string {
+	if m.Label != "" {
+		return m.Label
+	}
+	return "mcts"
+}
+
+func (m *MCTS) Move(g *game.Game) int {
+	// winsAt and the playouts drop discs on the board, so keep them off the
+	// caller's
+	g = g.CloneUntimed()
+	legal := legalColumns(g)
+	if len(legal) == 0 {
+		return 0
+	}
+	// random playouts are slow to notice a win in one, so settle those first
+	for _, p := range []int{g.Turn, opponent(g.Turn)} {
+		for _, c := range legal {
+			if winsAt(g, c, p) {
+				return c
+			}
+		}
+	}
+
+	c := m.Exploration
+	if c == 0 {
+		c = math.Sqrt2
+	}
+	limit := m.Iterations
+	if limit == 0 && m.Budget == 0 {
+		limit = defaultIterations
+	}
+	var deadline time.Time
+	if m.Budget > 0 {
+		deadline = time.Now().Add(m.Budget)
+	}
+
+	root := &mctsNode{player: opponent(g.Turn), untried: legal}
+	// the first iteration always runs, so the root has a child to pick
+	for i := 0; limit == 0 || i < limit; i++ {
+		if !deadline.IsZero() && i > 0 && i&63 == 0 && time.Now().After(deadline) {
+			break
+		}
+		s := g.Clone()
+		n := root
+		// selection
+		for len(n.untried) == 0 && len(n.children) > 0 {
+			n = n.best(c)
+			s.Drop(n.column, s.Turn)
+		}
+		// expansion
+		if !s.Finished && len(n.untried) > 0 {
+			k := rand.Intn(len(n.untried))
+			col := n.untried[k]
+			n.untried[k] = n.untried[len(n.untried)-1]
+			n.untried = n.untried[:len(n.untried)-1]
+			player := s.Turn
+			s.Drop(col, player)
+			child := &mctsNode{parent: n, column: col, player: player}
+			if !s.Finished {
+				child.untried = legalColumns(s)
+			}
+			n.children = append(n.children, child)
+			n = child
+		}
+		// simulation
+		for !s.Finished {
+			moves := legalColumns(s)
+			s.Drop(moves[rand.Intn(len(moves))], s.Turn)
+		}
+		// backpropagation
+		for ; n != nil; n = n.parent {
+			n.visits++
+			if s.Winner == n.player {
+				n.wins++
+			} else if s.Winner == 0 {
+				n.wins += 0.5
+			}
+		}
+	}
+
+	best := root.children[0]
+	for _, ch := range root.children[1:] {
+		if ch.visits > best.visits {
+			best = ch
+		}
+	}
+	return best.column
+}
+
+// best picks the child with the highest upper confidence bound.
+func (n *mctsNode) best(c float64) *mctsNode {
+	logN := math.Log(float64(n.visits))
+	var best *mctsNode
+	bestScore := math.Inf(-1)
+	for _, ch := range n.children {
+		score := ch.wins/float64(ch.visits) + c*math.Sqrt(logN/float64(ch.visits))
+		if score > bestScore {
+			best, bestScore = ch, score
+		}
+	}
+	return best
+}
+
+func legalColumns(g *game.Game) []int {
+	var cols []int
+	for c := 0; c < g.Rules.Cols; c++ {
+		if g.NextRow(c) >= 0 {
+			cols = append(cols, c)
+		}
+	}
+	return cols
+}
+
+// winsAt reports whether player would complete a line by dropping into col.
+// It puts a disc on g's board for a moment, so g must not be shared.
+func winsAt(g *game.Game, col, player int) bool {
+	r := g.NextRow(col)
+	g.Board[r][col] = player
+	win := g.CheckWin(r, col, player)
+	g.Board[r][col] = 0
+	return win
+}
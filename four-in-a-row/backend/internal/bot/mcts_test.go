@@ -0,0 +1,43 @@
+package bot
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"player/backend/internal/game"
+)
+
+func TestMCTSMove(t *testing.T) {
+	tests := []struct {
+		name  string
+		m     *MCTS
+		moves []int
+		want  int // -1 for any legal column
+	}{
+		{"out of time at once", &MCTS{Budget: time.Nanosecond}, nil, -1},
+		{"one playout", &MCTS{Iterations: 1}, []int{3, 3}, -1},
+		{"takes a win", &MCTS{Iterations: 50}, []int{3, 0, 3, 0, 3, 1}, 3},
+		{"blocks a win", &MCTS{Iterations: 50}, []int{3, 0, 3, 0, 3}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := game.Record{Result: game.ResultOngoing, Rules: game.DefaultRules(), Columns: tt.moves}
+			g, err := rec.Game()
+			if err != nil {
+				t.Fatal(err)
+			}
+			before := g.Clone()
+			col := tt.m.Move(g)
+			if !reflect.DeepEqual(g.Board, before.Board) {
+				t.Errorf("Move changed the caller's board:\n%s\nwas\n%s", g, before)
+			}
+			if g.NextRow(col) < 0 {
+				t.Fatalf("Move() = %d, not a legal column", col)
+			}
+			if tt.want >= 0 && col != tt.want {
+				t.Errorf("Move() = %d, want %d", col, tt.want)
+			}
+		})
+	}
+}
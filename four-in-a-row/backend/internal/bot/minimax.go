@@ -0,0 +1,238 @@
+package bot
+
+import (
+	"math"
+	"time"
+
+	"player/backend/internal/game"
+)
+
+// Minimax is a depth-limited negamax search with alpha-beta pruning. With a
+// Budget it deepens iteratively and plays the best move of the deepest
+// search that finished in time. A Table lets it reuse results for
+// positions reached through different move orders, and across moves.
+type Minimax struct {
+	Label  string
+	Depth  int           // plies to search, 0 to search until the board is full
+	Budget time.Duration // time per move, 0 for no limit
+	Table  *Table        // optional transposition table
+}
+
+func (m *Minimax) Name() string {
+	if m.Label != "" {
+		return m.Label
+	}
+	return "minimax"
+}
+
+func (m *Minimax) Move(g *game.Game) int {
+	col, _ := m.Search(g)
+	return col
+}
+
+// Search returns the chosen column and its score for the player to move.
+func (m *Minimax) Search(g *game.Game) (int, int) {
+	s := &search{
+		n:     newNode(g),
+		cells: g.Rules.Rows * g.Rules.Cols,
+		order: CenterOrder(g.Rules.Cols),
+		table: m.Table,
+		salt:  g.Rules.Hash(),
+	}
+	if m.Budget > 0 {
+		s.deadline = time.Now().Add(m.Budget)
+	}
+	maxDepth := s.cells - s.n.Plies()
+	if m.Depth > 0 && m.Depth < maxDepth {
+		maxDepth = m.Depth
+	}
+	order := s.order
+	best, bestScore := -1, 0
+	for _, c := range order {
+		if s.n.CanPlay(c) {
+			best = c
+			break
+		}
+	}
+	if best < 0 {
+		return 0, 0
+	}
+	start := 1
+	if m.Budget == 0 {
+		// without a deadline there is nothing to gain from shallower passes
+		start = maxDepth
+	}
+	for depth := start; depth <= maxDepth; depth++ {
+		col, score := s.root(depth, order)
+		if s.aborted {
+			break
+		}
+		best, bestScore = col, score
+		if score > WinScore-MaxPly || score < -WinScore+MaxPly {
+			break // result is already forced
+		}
+		// try this iteration's best move first next time
+		order = append([]int{col}, without(order, col)...)
+	}
+	return best, bestScore
+}
+
+type search struct {
+	n        node
+	cells    int
+	order    []int // columns in the order they are tried below the root
+	table    *Table
+	salt     uint64 // keeps positions from different rules apart in the table
+	deadline time.Time
+	nodes    int
+	aborted  bool
+}
+
+func (s *search) root(depth int, order []int) (int, int) {
+	for _, c := range order {
+		if s.n.CanPlay(c) && s.n.IsWinningMove(c) {
+			return c, WinScore - 1
+		}
+	}
+	best, bestScore := -1, math.MinInt32
+	alpha, beta := math.MinInt32+1, math.MaxInt32
+	for _, c := range order {
+		if !s.n.CanPlay(c) {
+			continue
+		}
+		s.n.Play(c)
+		score := -s.negamax(depth-1, -beta, -alpha, 1)
+		s.n.Undo(c)
+		if s.aborted {
+			return best, bestScore
+		}
+		if score > bestScore {
+			best, bestScore = c, score
+		}
+		if score > alpha {
+			alpha = score
+		}
+	}
+	return best, bestScore
+}
+
+// negamax scores the position for the player to move, ply moves below the root.
+func (s *search) negamax(depth, alpha, beta, ply int) int {
+	s.nodes++
+	if s.nodes&1023 == 0 && !s.deadline.IsZero() && time.Now().After(s.deadline) {
+		s.aborted = true
+	}
+	if s.aborted {
+		return 0
+	}
+	if s.n.Plies() == s.cells {
+		return 0 // draw
+	}
+	for _, c := range s.order {
+		if s.n.CanPlay(c) && s.n.IsWinningMove(c) {
+			return WinScore - ply - 1
+		}
+	}
+	if depth == 0 {
+		return s.n.Evaluate()
+	}
+
+	order := s.order
+	var key uint64
+	if s.table != nil {
+		key = s.n.Key() ^ s.salt
+		if e, ok := s.table.Probe(key); ok {
+			if int(e.Depth) >= depth {
+				score := fromTable(int(e.Score), ply)
+				switch {
+				case e.Bound == Exact:
+					return score
+				case e.Bound == Lower && score > alpha:
+					alpha = score
+				case e.Bound == Upper && score < beta:
+					beta = score
+				}
+				if alpha >= beta {
+					return score
+				}
+			}
+			if e.Move >= 0 {
+				order = append([]int{int(e.Move)}, without(s.order, int(e.Move))...)
+			}
+		}
+	}
+
+	alphaOrig := alpha
+	best, bestCol := math.MinInt32, -1
+	for _, c := range order {
+		if !s.n.CanPlay(c) {
+			continue
+		}
+		s.n.Play(c)
+		score := -s.negamax(depth-1, -beta, -alpha, ply+1)
+		s.n.Undo(c)
+		if score > best {
+			best, bestCol = score, c
+		}
+		if score > alpha {
+			alpha = score
+		}
+		if alpha >= beta {
+			break
+		}
+	}
+	if s.table != nil && !s.aborted {
+		bound := Exact
+		if best <= alphaOrig {
+			bound = Upper
+		} else if best >= beta {
+			bound = Lower
+		}
+		s.table.Store(Entry{Key: key, Score: int32(toTable(best, ply)), Depth: int16(depth), Move: int8(bestCol), Bound: bound})
+	}
+	return best
+}
+
+// Win and loss scores count plies from the root; the table stores them
+// counted from the position itself so they can be reused at any ply.
+func toTable(score, ply int) int {
+	switch {
+	case score > WinScore-MaxPly:
+		return score + ply
+	case score < -WinScore+MaxPly:
+		return score - ply
+	}
+	return score
+}
+
+func fromTable(score, ply int) int {
+	switch {
+	case score > WinScore-MaxPly:
+		return score - ply
+	case score < -WinScore+MaxPly:
+		return score + ply
+	}
+	return score
+}
+
+func emptyCells(g *game.Game) int {
+	n := 0
+	for _, row := range g.Board {
+		for _, v := range row {
+			if v == 0 {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+func without(cols []int, c int) []int {
+	out := make([]int, 0, len(cols))
+	for _, x := range cols {
+		if x != c {
+			out = append(out, x)
+		}
+	}
+	return out
+}
@@ -0,0 +1,106 @@
+package bot
+
+import (
+	"sync"
+
+	"player/backend/internal/game"
+)
+
+// node is the board a search runs on: a bitboard when the rules fit in
+// one, otherwise the game itself.
+type node interface {
+	Turn() int
+	Plies() int
+	CanPlay(col int) bool
+	IsWinningMove(col int) bool
+	Play(col int)
+	Undo(col int)
+	Evaluate() int // score for the player to move
+	Key() uint64   // identifies the position among boards of the same rules
+}
+
+// newNode picks the fastest representation available for g.
+func newNode(g *game.Game) node {
+	if p, err := game.PositionOf(g); err == nil {
+		return &bitNode{Position: p, geo: geometryFor(p)}
+	}
+	return &gameNode{g: g.CloneUntimed(), plies: g.Rules.Rows*g.Rules.Cols - emptyCells(g), hash: g.Hash()}
+}
+
+type bitNode struct {
+	*game.Position
+	geo *geometry
+}
+
+func (n *bitNode) Evaluate() int {
+	mine, theirs := n.Discs(n.Turn()), n.Discs(3-n.Turn())
+	connect := n.Rules().Connect
+	score := 0
+	for _, line := range n.geo.lines {
+		a, b := game.Count(mine&line), game.Count(theirs&line)
+		switch {
+		case b == 0:
+			score += lineWeight(a, connect)
+		case a == 0:
+			score -= lineWeight(b, connect)
+		}
+	}
+	score += 3 * (game.Count(mine&n.geo.center) - game.Count(theirs&n.geo.center))
+	return score
+}
+
+// geometry holds the masks evaluation needs for one set of rules.
+type geometry struct {
+	lines  []uint64
+	center uint64
+}
+
+var geometries sync.Map // game.Rules -> *geometry
+
+func geometryFor(p *game.Position) *geometry {
+	if g, ok := geometries.Load(p.Rules()); ok {
+		return g.(*geometry)
+	}
+	geo := &geometry{lines: p.Lines()}
+	for _, c := range centerColumns(p.Rules().Cols) {
+		geo.center |= p.Column(c)
+	}
+	geometries.Store(p.Rules(), geo)
+	return geo
+}
+
+// gameNode searches on the game itself, for boards too big for a bitboard.
+// Its key is the game's Zobrist hash, kept up to date move by move.
+type gameNode struct {
+	g     *game.Game
+	plies int
+	hash  uint64
+}
+
+func (n *gameNode) Turn() int            { return n.g.Turn }
+func (n *gameNode) Plies() int           { return n.plies }
+func (n *gameNode) CanPlay(col int) bool { return n.g.NextRow(col) >= 0 }
+func (n *gameNode) Evaluate() int        { return Evaluate(n.g, n.g.Turn) }
+
+func (n *gameNode) Key() uint64 { return n.hash }
+
+func (n *gameNode) Play(col int) {
+	p := n.g.Turn
+	r, _ := n.g.Drop(col, p)
+	n.hash ^= game.ZobristKey(p, r, col) ^ game.ZobristSide()
+	n.plies++
+}
+
+func (n *gameNode) Undo(col int) {
+	m, _ := n.g.Undo()
+	n.hash ^= game.ZobristKey(m.Player, m.Row, m.Column) ^ game.ZobristSide()
+	n.plies--
+}
+
+func (n *gameNode) IsWinningMove(col int) bool {
+	r := n.g.NextRow(col)
+	n.g.Board[r][col] = n.g.Turn
+	won := n.g.CheckWin(r, col, n.g.Turn)
+	n.g.Board[r][col] = 0
+	return won
+}
@@ -0,0 +1,345 @@
+package bot
+
+import (
+	"errors"
+	"time"
+
+	"player/backend/internal/game"
+)
+
+// Results of a solved position, for the player to move.
+const (
+	ResultWin     = "win"
+	ResultLoss    = "loss"
+	ResultDraw    = "draw"
+	ResultUnknown = "unknown" // not solved within the time budget
+)
+
+var ErrGameOver = errors.New("game is already over")
+
+// Value is the outcome of a position with perfect play from both sides.
+//
+// Score follows the usual solver convention: a side that wins with a disc
+// played when m discs are already on a board of n cells scores (n+1-m)/2,
+// so faster wins score higher, the loser scores the negative and a draw is
+// 0. PliesToEnd is how many moves, counting both players, remain until the
+// game is decided.
+type Value struct {
+	Result     string `json:"result"`
+	Score      int    `json:"score"`
+	PliesToEnd int    `json:"plies_to_end"`
+}
+
+// ColumnValue is the value of playing a column, for the player to move.
+type ColumnValue struct {
+	Column int    `json:"column"`
+	Legal  bool   `json:"legal"`
+	Value  *Value `json:"value,omitempty"`
+	Eval   *int   `json:"eval,omitempty"` // heuristic score when the column was not solved
+}
+
+// Analysis is a solved position with the value of every column.
+type Analysis struct {
+	Turn    int           `json:"turn"`
+	Plies   int           `json:"plies"`
+	Value   Value         `json:"value"`
+	Columns []ColumnValue `json:"columns"`
+	Best    []int         `json:"best"` // columns that keep the best result
+	Nodes   int           `json:"nodes"`
+}
+
+// Solver searches positions to the end of the game. It only handles boards
+// that fit in a game.Position.
+//
+// As an Engine it plays a best column whenever it can solve the position in
+// time, and leaves the move to Fallback otherwise.
+type Solver struct {
+	Label    string
+	Budget   time.Duration // time allowed per call, 0 for no limit
+	Table    *Table        // optional, speeds up repeated solving
+	Fallback Engine        // plays positions that could not be solved
+}
+
+// SolverTable is shared by solvers created with NewSolver and the perfect
+// level. Solved scores are exact, so any solver can use them.
+var SolverTable = TableFor("solver")
+
+func NewSolver(budget time.Duration) *Solver {
+	return &Solver{Budget: budget, Table: SolverTable}
+}
+
+func (s *Solver) Name() string {
+	if s.Label != "" {
+		return s.Label
+	}
+	return "solver"
+}
+
+func (s *Solver) Move(g *game.Game) int {
+	a, err := s.Analyze(g)
+	if err == nil && len(a.Best) > 0 {
+		return closestToCenter(a.Best, g.Rules.Cols)
+	}
+	fallback := s.Fallback
+	if fallback == nil {
+		fallback = &Minimax{Depth: 6}
+	}
+	return fallback.Move(g)
+}
+
+// Solve returns the value of g for the player to move.
+func (s *Solver) Solve(g *game.Game) (Value, error) {
+	if g.Finished {
+		return Value{}, ErrGameOver
+	}
+	p, err := game.PositionOf(g)
+	if err != nil {
+		return Value{}, err
+	}
+	sv := s.start(p)
+	score, ok := sv.solve()
+	if !ok {
+		return Value{Result: ResultUnknown}, nil
+	}
+	return sv.value(score, p.Plies()), nil
+}
+
+// Analyze solves g and every position one move away from it.
+func (s *Solver) Analyze(g *game.Game) (*Analysis, error) {
+	if g.Finished {
+		return nil, ErrGameOver
+	}
+	p, err := game.PositionOf(g)
+	if err != nil {
+		return nil, err
+	}
+	sv := s.start(p)
+	a := &Analysis{Turn: p.Turn(), Plies: p.Plies(), Value: Value{Result: ResultUnknown}}
+	legal := p.LegalColumns(nil)
+	best, solved := 0, true
+	for c := 0; c < g.Rules.Cols; c++ {
+		cv := ColumnValue{Column: c, Legal: p.CanPlay(c)}
+		if !cv.Legal {
+			a.Columns = append(a.Columns, cv)
+			continue
+		}
+		// share what is left of the budget between the remaining columns,
+		// so one hard column does not leave the others unsolved
+		if !sv.deadline.IsZero() {
+			left := time.Until(sv.end) / time.Duration(len(legal))
+			sv.deadline, sv.aborted = time.Now().Add(left), false
+		}
+		legal = legal[1:]
+		var score int
+		var ok bool
+		if p.IsWinningMove(c) {
+			score, ok = (sv.cells+1-p.Plies())/2, true
+		} else {
+			p.Play(c)
+			if p.Full() {
+				score, ok = 0, true
+			} else {
+				score, ok = sv.solve()
+				score = -score
+			}
+			p.Undo(c)
+		}
+		if ok {
+			v := sv.value(score, p.Plies())
+			cv.Value = &v
+			if len(a.Best) == 0 || score > best {
+				best, a.Best = score, []int{c}
+			} else if score == best {
+				a.Best = append(a.Best, c)
+			}
+		} else {
+			solved = false
+			child := g.CloneUntimed()
+			child.Drop(c, child.Turn)
+			_, e := (&Minimax{Depth: 6}).Search(child)
+			e = -e
+			cv.Eval = &e
+		}
+		a.Columns = append(a.Columns, cv)
+	}
+	if solved {
+		a.Value = sv.value(best, p.Plies())
+	} else {
+		// a column we could not solve might be better than the ones we did
+		a.Best = nil
+	}
+	a.Nodes = sv.nodes
+	return a, nil
+}
+
+type solver struct {
+	p        *game.Position
+	geo      *geometry
+	table    *Table
+	salt     uint64
+	cells    int
+	order    []int
+	deadline time.Time
+	end      time.Time // deadline of the whole call
+	nodes    int
+	aborted  bool
+}
+
+func (s *Solver) start(p *game.Position) *solver {
+	r := p.Rules()
+	sv := &solver{
+		p:     p,
+		geo:   geometryFor(p),
+		table: s.Table,
+		salt:  r.Hash() ^ 0x50_4c_56, // differ from Minimax keys if both share a table
+		cells: r.Rows * r.Cols,
+		order: CenterOrder(r.Cols),
+	}
+	if s.Budget > 0 {
+		sv.deadline = time.Now().Add(s.Budget)
+		sv.end = sv.deadline
+	}
+	return sv
+}
+
+// value turns a score for the player to move in the root position into a Value.
+func (s *solver) value(score, plies int) Value {
+	v := Value{Score: score, Result: ResultDraw, PliesToEnd: s.cells - plies}
+	if score == 0 {
+		return v
+	}
+	// a side that wins with a disc played when m discs were already down
+	// scores (cells+1-m)/2; of the two m that fit, pick the one on the
+	// winner's turn
+	k, winner := score, plies%2
+	v.Result = ResultWin
+	if score < 0 {
+		k, winner = -score, 1-winner
+		v.Result = ResultLoss
+	}
+	m := s.cells + 1 - 2*k
+	if m%2 != winner {
+		m--
+	}
+	v.PliesToEnd = m + 1 - plies
+	return v
+}
+
+// solve finds the exact score with a series of null-window searches that
+// narrow the range the score can lie in.
+func (s *solver) solve() (int, bool) {
+	plies := s.p.Plies()
+	min, max := -(s.cells-plies)/2, (s.cells+1-plies)/2
+	for min < max {
+		med := min + (max-min)/2
+		if med <= 0 && min/2 < med {
+			med = min / 2
+		} else if med >= 0 && max/2 > med {
+			med = max / 2
+		}
+		r := s.negamax(med, med+1)
+		if s.aborted {
+			return 0, false
+		}
+		if r <= med {
+			max = r
+		} else {
+			min = r
+		}
+	}
+	return min, true
+}
+
+// negamax returns the exact score if it lies within (alpha, beta), otherwise
+// a bound on the side of the window it falls.
+func (s *solver) negamax(alpha, beta int) int {
+	s.nodes++
+	if s.nodes&4095 == 0 && !s.deadline.IsZero() && time.Now().After(s.deadline) {
+		s.aborted = true
+	}
+	if s.aborted {
+		return 0
+	}
+	p := s.p
+	plies := p.Plies()
+	if p.CanWinNext() {
+		return (s.cells + 1 - plies) / 2
+	}
+	next := p.NonLosingMoves()
+	if next == 0 {
+		return -(s.cells - plies) / 2
+	}
+	if plies >= s.cells-2 {
+		return 0
+	}
+
+	// the opponent cannot win on their next move, nor we on this one
+	if min := -(s.cells - 2 - plies) / 2; alpha < min {
+		alpha = min
+		if alpha >= beta {
+			return alpha
+		}
+	}
+	max := (s.cells - 1 - plies) / 2
+	key := p.Key() ^ s.salt
+	if s.table != nil {
+		if e, ok := s.table.Probe(key); ok && e.Bound == Upper && int(e.Score) < max {
+			max = int(e.Score)
+		}
+	}
+	if beta > max {
+		beta = max
+		if alpha >= beta {
+			return beta
+		}
+	}
+
+	var moves [game.MaxSize]int
+	for _, c := range s.sorted(next, moves[:0]) {
+		p.Play(c)
+		score := -s.negamax(-beta, -alpha)
+		p.Undo(c)
+		if score >= beta {
+			return score
+		}
+		if score > alpha {
+			alpha = score
+		}
+	}
+	if s.table != nil && !s.aborted {
+		s.table.Store(Entry{Key: key, Score: int32(alpha), Move: -1, Bound: Upper})
+	}
+	return alpha
+}
+
+// sorted appends the columns of the cells in next to dst, the moves that
+// create the most winning cells first and center columns breaking ties.
+func (s *solver) sorted(next uint64, dst []int) []int {
+	var scores [game.MaxSize]int
+	for _, c := range s.order {
+		cell := next & s.p.Column(c)
+		if cell == 0 {
+			continue
+		}
+		score := s.p.MoveScore(cell)
+		i := len(dst)
+		dst = append(dst, c)
+		for i > 0 && scores[i-1] < score {
+			dst[i], scores[i] = dst[i-1], scores[i-1]
+			i--
+		}
+		dst[i], scores[i] = c, score
+	}
+	return dst
+}
+
+func closestToCenter(cols []int, n int) int {
+	for _, c := range CenterOrder(n) {
+		for _, b := range cols {
+			if b == c {
+				return c
+			}
+		}
+	}
+	return cols[0]
+}
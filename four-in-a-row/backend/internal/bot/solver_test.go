@@ -0,0 +1,177 @@
+package bot
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+	"time"
+
+	"player/backend/internal/game"
+)
+
+// bruteForce scores a position for the player to move by trying every
+// line of play, with the solver's scoring but none of its pruning.
+type bruteForce struct {
+	cells int
+	memo  map[uint64]int
+}
+
+func (b *bruteForce) score(p *game.Position) int {
+	if s, ok := b.memo[p.Key()]; ok {
+		return s
+	}
+	legal := p.LegalColumns(nil)
+	best := -b.cells
+	for _, c := range legal {
+		if p.IsWinningMove(c) {
+			best = (b.cells + 1 - p.Plies()) / 2
+			break
+		}
+		p.Play(c)
+		s := 0
+		if !p.Full() {
+			s = -b.score(p)
+		}
+		p.Undo(c)
+		if s > best {
+			best = s
+		}
+	}
+	b.memo[p.Key()] = best
+	return best
+}
+
+// randomGame plays up to plies random moves that do not end the game.
+func randomGame(t *testing.T, rules game.Rules, rng *rand.Rand, plies int) *game.Game {
+	t.Helper()
+	g, err := game.NewGameWithRules(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < plies; i++ {
+		cols := rng.Perm(rules.Cols)
+		played := false
+		for _, c := range cols {
+			if g.NextRow(c) < 0 {
+				continue
+			}
+			next := g.CloneUntimed()
+			if _, err := next.Drop(c, next.Turn); err != nil || next.Finished {
+				continue
+			}
+			g, played = next, true
+			break
+		}
+		if !played {
+			break
+		}
+	}
+	return g
+}
+
+func TestSolverMatchesBruteForce(t *testing.T) {
+	tests := []struct {
+		rules game.Rules
+		plies []int
+	}{
+		{game.Rules{Rows: 4, Cols: 4, Connect: 3}, []int{0, 1, 2, 4, 6, 8}},
+		{game.Rules{Rows: 4, Cols: 4, Connect: 4}, []int{0, 2, 5, 8}},
+		{game.Rules{Rows: 4, Cols: 5, Connect: 4}, []int{3, 6, 9, 12}},
+		{game.Rules{Rows: 5, Cols: 4, Connect: 3}, []int{4, 8, 12}},
+	}
+	rng := rand.New(rand.NewSource(7))
+	for _, tt := range tests {
+		bf := &bruteForce{cells: tt.rules.Rows * tt.rules.Cols, memo: map[uint64]int{}}
+		for _, plies := range tt.plies {
+			for n := 0; n < 5; n++ {
+				g := randomGame(t, tt.rules, rng, plies)
+				p, err := game.PositionOf(g)
+				if err != nil {
+					t.Fatal(err)
+				}
+				want := bf.score(p)
+				s := &Solver{Table: NewTable(1 << 12)}
+				v, err := s.Solve(g)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if v.Score != want {
+					t.Errorf("%v after %v: score %d, want %d", tt.rules, g.Moves, v.Score, want)
+				}
+				a, err := s.Analyze(g)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if a.Value.Score != want {
+					t.Errorf("%v after %v: analysis score %d, want %d", tt.rules, g.Moves, a.Value.Score, want)
+				}
+				for _, cv := range a.Columns {
+					if !cv.Legal {
+						continue
+					}
+					colWant := (bf.cells + 1 - p.Plies()) / 2
+					if !p.IsWinningMove(cv.Column) {
+						p.Play(cv.Column)
+						colWant = 0
+						if !p.Full() {
+							colWant = -bf.score(p)
+						}
+						p.Undo(cv.Column)
+					}
+					if cv.Value == nil || cv.Value.Score != colWant {
+						t.Errorf("%v after %v: column %d value %+v, want score %d", tt.rules, g.Moves, cv.Column, cv.Value, colWant)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestSolverValue(t *testing.T) {
+	tests := []struct {
+		name   string
+		rules  game.Rules
+		moves  []int
+		result string
+		score  int
+		plies  int
+	}{
+		// the empty 4x4 connect 4 board cannot be won by either side
+		{"empty 4x4", game.Rules{Rows: 4, Cols: 4, Connect: 4}, nil, ResultDraw, 0, 16},
+		// three stacked in column 4: the next disc there wins
+		{"win now", game.DefaultRules(), []int{3, 0, 3, 0, 3, 1}, ResultWin, 18, 1},
+		// the opponent has three open at both ends on the bottom row
+		{"lost in two", game.DefaultRules(), []int{2, 0, 3, 0, 4}, ResultLoss, -18, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := game.Record{Result: game.ResultOngoing, Rules: tt.rules, Columns: tt.moves}
+			g, err := rec.Game()
+			if err != nil {
+				t.Fatal(err)
+			}
+			v, err := NewSolver(0).Solve(g)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v.Result != tt.result || v.Score != tt.score || v.PliesToEnd != tt.plies {
+				t.Errorf("Solve() = %+v, want %s score %d in %d plies", v, tt.result, tt.score, tt.plies)
+			}
+		})
+	}
+}
+
+func TestSolverErrors(t *testing.T) {
+	rec := game.Record{Result: game.ResultOngoing, Rules: game.DefaultRules(), Columns: []int{3, 0, 3, 0, 3, 0, 3}}
+	g, err := rec.Game()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewSolver(0).Solve(g); !errors.Is(err, ErrGameOver) {
+		t.Errorf("Solve(finished) error = %v, want %v", err, ErrGameOver)
+	}
+	v, err := (&Solver{Budget: time.Nanosecond}).Solve(game.NewGame())
+	if err != nil || v.Result != ResultUnknown {
+		t.Errorf("Solve(empty board, no time) = %+v, %v, want %s", v, err, ResultUnknown)
+	}
+}
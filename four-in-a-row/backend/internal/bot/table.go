@@ -0,0 +1,148 @@
+package bot
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Bound says how a stored score relates to the true value of a position.
+type Bound uint8
+
+const (
+	Exact Bound = iota + 1
+	Lower       // true value is at least Score
+	Upper       // true value is at most Score
+)
+
+// Entry is a search result remembered for a position.
+type Entry struct {
+	Key   uint64
+	Score int32
+	Depth int16
+	Move  int8 // best column found, -1 if none
+	Bound Bound
+}
+
+// Table is a fixed-size transposition table shared by concurrent searches.
+// Each key maps to one slot; a new result replaces the old one unless the
+// old one was searched deeper for the same position.
+type Table struct {
+	slots []Entry
+	locks [64]sync.Mutex
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+	stores atomic.Uint64
+}
+
+// TableStats reports how well a table is doing.
+type TableStats struct {
+	Size    int     `json:"size"`
+	Hits    uint64  `json:"hits"`
+	Misses  uint64  `json:"misses"`
+	Stores  uint64  `json:"stores"`
+	HitRate float64 `json:"hit_rate"`
+}
+
+// DefaultTableSize is the number of entries in each engine's table, 16 MiB.
+const DefaultTableSize = 1 << 20
+
+// Every level searches to its own depth, so each has a table of its own:
+// sharing one would let an easy bot reuse what a hard one worked out.
+var (
+	tablesMu sync.Mutex
+	tables   = map[string]*Table{}
+)
+
+// TableFor returns the table of the engine with a name, made the first time
+// it is asked for.
+func TableFor(name string) *Table {
+	tablesMu.Lock()
+	defer tablesMu.Unlock()
+	t := tables[name]
+	if t == nil {
+		t = NewTable(DefaultTableSize)
+		tables[name] = t
+	}
+	return t
+}
+
+// AllTableStats reports on every table made by TableFor, by engine name.
+func AllTableStats() map[string]TableStats {
+	tablesMu.Lock()
+	defer tablesMu.Unlock()
+	res := make(map[string]TableStats, len(tables))
+	for name, t := range tables {
+		res[name] = t.Stats()
+	}
+	return res
+}
+
+func NewTable(size int) *Table {
+	if size < 1 {
+		size = 1
+	}
+	return &Table{slots: make([]Entry, size)}
+}
+
+func (t *Table) slot(key uint64) (int, *sync.Mutex) {
+	// bitboard keys only differ in the bits of the columns played, so mix
+	// them before picking a slot or most of the table goes unused
+	key *= 0x9e3779b97f4a7c15
+	i := int((key >> 32) % uint64(len(t.slots)))
+	return i, &t.locks[i%len(t.locks)]
+}
+
+// Probe looks up a position.
+func (t *Table) Probe(key uint64) (Entry, bool) {
+	i, mu := t.slot(key)
+	mu.Lock()
+	e := t.slots[i]
+	mu.Unlock()
+	if e.Bound == 0 || e.Key != key {
+		t.misses.Add(1)
+		return Entry{}, false
+	}
+	t.hits.Add(1)
+	return e, true
+}
+
+// Store records a search result.
+func (t *Table) Store(e Entry) {
+	i, mu := t.slot(e.Key)
+	mu.Lock()
+	if old := t.slots[i]; old.Key != e.Key || old.Depth <= e.Depth {
+		t.slots[i] = e
+	}
+	mu.Unlock()
+	t.stores.Add(1)
+}
+
+// Clear empties the table and resets its statistics.
+func (t *Table) Clear() {
+	for i := range t.locks {
+		t.locks[i].Lock()
+	}
+	for i := range t.slots {
+		t.slots[i] = Entry{}
+	}
+	for i := range t.locks {
+		t.locks[i].Unlock()
+	}
+	t.hits.Store(0)
+	t.misses.Store(0)
+	t.stores.Store(0)
+}
+
+func (t *Table) Stats() TableStats {
+	s := TableStats{
+		Size:   len(t.slots),
+		Hits:   t.hits.Load(),
+		Misses: t.misses.Load(),
+		Stores: t.stores.Load(),
+	}
+	if probes := s.Hits + s.Misses; probes > 0 {
+		s.HitRate = float64(s.Hits) / float64(probes)
+	}
+	return s
+}
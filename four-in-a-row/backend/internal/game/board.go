@@ -0,0 +1,79 @@
+package game
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrBadBoard = errors.New("bad board")
+
+// FromBoard builds a game from a bare board, Board[row][col] with row 0 at
+// the bottom, for positions whose move order is unknown. Discs must rest on
+// each other and the players must have alternated, player 1 first, which
+// also fixes whose turn it is. Moves is left empty.
+func FromBoard(rules Rules, board [][]int) (*Game, error) {
+	g, err := NewGameWithRules(rules)
+	if err != nil {
+		return nil, err
+	}
+	if len(board) != rules.Rows {
+		return nil, fmt.Errorf("%w: %d rows, rules say %d", ErrBadBoard, len(board), rules.Rows)
+	}
+	var count [3]int
+	for r, row := range board {
+		if len(row) != rules.Cols {
+			return nil, fmt.Errorf("%w: row %d has %d columns, rules say %d", ErrBadBoard, r, len(row), rules.Cols)
+		}
+		for c, v := range row {
+			if v < 0 || v > 2 {
+				return nil, fmt.Errorf("%w: cell %d,%d holds %d", ErrBadBoard, r, c, v)
+			}
+			if v != 0 && r > 0 && board[r-1][c] == 0 {
+				return nil, fmt.Errorf("%w: disc at %d,%d is floating", ErrBadBoard, r, c)
+			}
+			g.Board[r][c] = v
+			count[v]++
+		}
+	}
+	switch count[1] - count[2] {
+	case 0:
+		g.Turn = 1
+	case 1:
+		g.Turn = 2
+	default:
+		return nil, fmt.Errorf("%w: player 1 has %d discs and player 2 has %d", ErrBadBoard, count[1], count[2])
+	}
+	for r := range g.Board {
+		for c, v := range g.Board[r] {
+			if v == 0 || !g.CheckWin(r, c, v) {
+				continue
+			}
+			if g.Winner != 0 && g.Winner != v {
+				return nil, fmt.Errorf("%w: both players have a line", ErrBadBoard)
+			}
+			g.Winner = v
+		}
+	}
+	g.endOnBoard()
+	return g, nil
+}
+
+// Mirror returns a copy of the game flipped left to right, moves included.
+// Mirrored positions have the same value, so books and tables can share
+// what they know about them.
+func (g *Game) Mirror() *Game {
+	m := g.Clone()
+	last := g.Rules.Cols - 1
+	for r, row := range g.Board {
+		for c, v := range row {
+			m.Board[r][last-c] = v
+		}
+	}
+	for i := range m.Moves {
+		m.Moves[i].Column = last - m.Moves[i].Column
+	}
+	for i := range m.undone {
+		m.undone[i].Column = last - m.undone[i].Column
+	}
+	return m
+}
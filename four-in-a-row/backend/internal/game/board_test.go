@@ -0,0 +1,43 @@
+package game
+
+import "testing"
+
+func TestFromBoard(t *testing.T) {
+	tests := []struct {
+		name       string
+		board      [][]int
+		turn       int
+		winner     int
+		reason     string
+		wantFinish bool
+	}{
+		{"empty", nil, 1, 0, "", false},
+		{"ongoing", [][]int{{0, 0, 1, 2, 0, 0, 0}}, 1, 0, "", false},
+		{"won by a line", [][]int{{1, 1, 1, 1, 0, 0, 0}, {2, 2, 2, 0, 0, 0, 0}}, 2, 1, EndLine, true},
+		{"board full", [][]int{
+			{1, 1, 2, 2, 1, 1, 2},
+			{2, 2, 1, 1, 2, 2, 1},
+			{1, 1, 2, 2, 1, 1, 2},
+			{2, 2, 1, 1, 2, 2, 1},
+			{1, 1, 2, 2, 1, 1, 2},
+			{2, 2, 1, 1, 2, 2, 1},
+		}, 1, 0, EndBoardFull, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules := DefaultRules()
+			board := newBoard(rules.Rows, rules.Cols)
+			for r, row := range tt.board {
+				copy(board[r], row)
+			}
+			g, err := FromBoard(rules, board)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if g.Turn != tt.turn || g.Finished != tt.wantFinish || g.Winner != tt.winner || g.Reason != tt.reason {
+				t.Errorf("FromBoard: turn %d, finished %v, winner %d, reason %q, want turn %d, finished %v, winner %d, reason %q",
+					g.Turn, g.Finished, g.Winner, g.Reason, tt.turn, tt.wantFinish, tt.winner, tt.reason)
+			}
+		})
+	}
+}
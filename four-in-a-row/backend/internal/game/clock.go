@@ -0,0 +1,223 @@
+package game
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TimeControl is how much time players get. It is written "3m+2s" for three
+// minutes each plus two seconds added after every move, or "30s/move" for
+// thirty seconds per move that do not carry over. The zero value is an
+// untimed game.
+type TimeControl struct {
+	Initial   time.Duration
+	Increment time.Duration
+	PerMove   time.Duration
+}
+
+var ErrBadTimeControl = errors.New("bad time control")
+var ErrFlagFall = errors.New("out of time")
+
+// ParseTimeControl reads a time control; an empty string is untimed.
+func ParseTimeControl(s string) (TimeControl, error) {
+	var tc TimeControl
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return tc, nil
+	}
+	var err error
+	if per, ok := strings.CutSuffix(s, "/move"); ok {
+		tc.PerMove, err = time.ParseDuration(per)
+	} else {
+		initial, inc, _ := strings.Cut(s, "+")
+		if tc.Initial, err = time.ParseDuration(initial); err == nil && inc != "" {
+			tc.Increment, err = time.ParseDuration(inc)
+		}
+	}
+	if err != nil {
+		return TimeControl{}, fmt.Errorf("%w %q: %v", ErrBadTimeControl, s, err)
+	}
+	if tc.Initial < 0 || tc.Increment < 0 || tc.PerMove < 0 || (tc.Initial == 0 && tc.PerMove == 0) {
+		return TimeControl{}, fmt.Errorf("%w %q", ErrBadTimeControl, s)
+	}
+	return tc, nil
+}
+
+func (tc TimeControl) IsZero() bool {
+	return tc == TimeControl{}
+}
+
+func (tc TimeControl) String() string {
+	switch {
+	case tc.IsZero():
+		return ""
+	case tc.PerMove > 0:
+		return shortDuration(tc.PerMove) + "/move"
+	}
+	return shortDuration(tc.Initial) + "+" + shortDuration(tc.Increment)
+}
+
+// shortDuration drops the zero units time.Duration.String keeps, so three
+// minutes is "3m" rather than "3m0s".
+func shortDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
+
+func (tc TimeControl) MarshalText() ([]byte, error) {
+	return []byte(tc.String()), nil
+}
+
+func (tc *TimeControl) UnmarshalText(b []byte) error {
+	v, err := ParseTimeControl(string(b))
+	if err != nil {
+		return err
+	}
+	*tc = v
+	return nil
+}
+
+// Clock keeps both players' time. Only the player to move has a running
+// clock; it is charged when they move, so the server's clock is the one
+// that counts, whatever the clients show.
+type Clock struct {
+	Control   TimeControl
+	Remaining [2]time.Duration // as of Since for the running player
+	Running   int              // player whose clock runs, 0 when stopped
+	Since     time.Time
+}
+
+func NewClock(tc TimeControl) *Clock {
+	c := &Clock{Control: tc}
+	start := tc.Initial
+	if tc.PerMove > 0 {
+		start = tc.PerMove
+	}
+	c.Remaining = [2]time.Duration{start, start}
+	return c
+}
+
+// Start runs player's clock from now.
+func (c *Clock) Start(player int, now time.Time) {
+	c.Running, c.Since = player, now
+}
+
+// Left returns the time player has left at now, never below zero.
+func (c *Clock) Left(player int, now time.Time) time.Duration {
+	left := c.Remaining[player-1]
+	if c.Running == player {
+		left -= now.Sub(c.Since)
+	}
+	if left < 0 {
+		return 0
+	}
+	return left
+}
+
+// Stop charges the running player for the time used and stops the clock.
+func (c *Clock) Stop(now time.Time) {
+	if c.Running == 0 {
+		return
+	}
+	c.Remaining[c.Running-1] = c.Left(c.Running, now)
+	c.Running = 0
+}
+
+// Switch ends the running player's turn, adding their increment or
+// resetting their move time, and starts next's clock.
+func (c *Clock) Switch(next int, now time.Time) {
+	if p := c.Running; p != 0 {
+		c.Stop(now)
+		if c.Control.PerMove > 0 {
+			c.Remaining[p-1] = c.Control.PerMove
+		} else {
+			c.Remaining[p-1] += c.Control.Increment
+		}
+	}
+	c.Start(next, now)
+}
+
+func (c *Clock) clone() *Clock {
+	if c == nil {
+		return nil
+	}
+	cc := *c
+	return &cc
+}
+
+// MarshalJSON writes the clock as clients see it: each player's time left
+// in milliseconds at the moment it is sent, and whose clock is running.
+func (c *Clock) MarshalJSON() ([]byte, error) {
+	now := time.Now()
+	return json.Marshal(struct {
+		Control     TimeControl `json:"control"`
+		RemainingMs [2]int64    `json:"remaining_ms"`
+		Running     int         `json:"running"`
+	}{
+		Control:     c.Control,
+		RemainingMs: [2]int64{c.Left(1, now).Milliseconds(), c.Left(2, now).Milliseconds()},
+		Running:     c.Running,
+	})
+}
+
+// UnmarshalJSON restarts the running clock from the time it is read.
+func (c *Clock) UnmarshalJSON(data []byte) error {
+	var v struct {
+		Control     TimeControl `json:"control"`
+		RemainingMs [2]int64    `json:"remaining_ms"`
+		Running     int         `json:"running"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*c = Clock{Control: v.Control, Running: v.Running, Since: time.Now()}
+	for i, ms := range v.RemainingMs {
+		c.Remaining[i] = time.Duration(ms) * time.Millisecond
+	}
+	return nil
+}
+
+// CloneUntimed returns a copy of the game without its clock, for searches
+// that play moves ahead and must not run anybody out of time.
+func (g *Game) CloneUntimed() *Game {
+	c := g.Clone()
+	c.Clock = nil
+	return c
+}
+
+// SetTimeControl puts the game on a clock, player 1's running from now.
+// A zero time control leaves the game untimed.
+func (g *Game) SetTimeControl(tc TimeControl) {
+	if tc.IsZero() {
+		g.Clock = nil
+		return
+	}
+	g.Clock = NewClock(tc)
+	if !g.Finished {
+		g.Clock.Start(g.Turn, time.Now())
+	}
+}
+
+// CheckFlag ends the game if the player to move has run out of time at
+// now, a loss for them, and reports whether it did.
+func (g *Game) CheckFlag(now time.Time) bool {
+	if g.Finished || g.Clock == nil || g.Clock.Running == 0 {
+		return false
+	}
+	if g.Clock.Left(g.Clock.Running, now) > 0 {
+		return false
+	}
+	loser := g.Clock.Running
+	g.Clock.Stop(now)
+	g.end(3-loser, EndTimeout)
+	return true
+}
@@ -1,6 +1,7 @@
 package game
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"math/rand"
@@ -8,89 +9,360 @@ import (
 )
 
 const (
-	Cols = 7
-	Rows = 6
+	DefaultCols    = 7
+	DefaultRows    = 6
+	DefaultConnect = 4
+
+	MinSize    = 4
+	MaxSize    = 12
+	MinConnect = 3
+
+	// HintLimit is how many hints each player may ask for in one game.
+	HintLimit = 3
 )
 
+// Rules describes the board size and how many discs in a line win.
+type Rules struct {
+	Rows    int `json:"rows"`
+	Cols    int `json:"cols"`
+	Connect int `json:"connect"`
+}
+
+// DefaultRules returns the classic 6x7 connect-four rules.
+func DefaultRules() Rules {
+	return Rules{Rows: DefaultRows, Cols: DefaultCols, Connect: DefaultConnect}
+}
+
+var ErrInvalidRules = errors.New("invalid rules")
+
+// Validate checks that the board fits the supported range and that the
+// connect length can actually be reached on it.
+func (r Rules) Validate() error {
+	if r.Rows < MinSize || r.Rows > MaxSize || r.Cols < MinSize || r.Cols > MaxSize {
+		return fmt.Errorf("%w: board must be between %dx%d and %dx%d", ErrInvalidRules, MinSize, MinSize, MaxSize, MaxSize)
+	}
+	if r.Connect < MinConnect || (r.Connect > r.Rows && r.Connect > r.Cols) {
+		return fmt.Errorf("%w: connect %d does not fit a %dx%d board", ErrInvalidRules, r.Connect, r.Rows, r.Cols)
+	}
+	return nil
+}
+
 type Player int
 
+// Reasons a game can end, kept in Game.Reason.
+const (
+	EndLine       = "line"        // a player connected a line
+	EndBoardFull  = "board_full"  // nobody did before the board filled up
+	EndTimeout    = "timeout"     // a player ran out of time
+	EndAbandoned  = "abandoned"   // a player left and did not come back
+	EndResigned   = "resigned"    // a player gave up
+	EndDrawAgreed = "draw_agreed" // both players agreed to a draw
+)
+
+// Move is a single disc placed on the board.
+type Move struct {
+	Player int       `json:"player"`
+	Column int       `json:"column"`
+	Row    int       `json:"row"`
+	At     time.Time `json:"at"`
+}
+
 type Game struct {
-	ID       string          `json:"id"`
-	Board    [Rows][Cols]int `json:"board"`
-	Turn     int             `json:"turn"` // which player (1 or 2)
-	Finished bool            `json:"finished"`
-	Winner   int             `json:"winner"` // 0 none, 1 or 2
+	ID       string  `json:"id"`
+	Rules    Rules   `json:"rules"`
+	Board    [][]int `json:"board"` // Board[row][col], row 0 is the bottom
+	Turn     int     `json:"turn"`  // which player (1 or 2)
+	Finished bool    `json:"finished"`
+	Winner   int     `json:"winner"`           // 0 none, 1 or 2
+	Moves    []Move  `json:"moves"`            // every move played, oldest first
+	Hints    [2]int  `json:"hints"`            // hints used by player 1 and player 2
+	Clock    *Clock  `json:"clock,omitempty"`  // nil for untimed games
+	Reason   string  `json:"reason,omitempty"` // why a finished game ended, one of the End constants
+	Offer    *Offer  `json:"offer,omitempty"`  // draw or takeback waiting for an answer
+	Casual   bool    `json:"casual,omitempty"` // never ranked, e.g. because a guest is playing
+
+	undone []Move // moves taken back by Undo, most recent last
 }
 
 func init() {
 	rand.Seed(time.Now().UnixNano())
 }
 
+// NewGame creates a game with the default rules.
 func NewGame() *Game {
-	return &Game{ID: fmt.Sprintf("g-%d", rand.Int63()), Turn: 1}
+	g, _ := NewGameWithRules(DefaultRules())
+	return g
+}
+
+// NewGameWithRules creates an empty game for the given board size and connect length.
+func NewGameWithRules(rules Rules) (*Game, error) {
+	if err := rules.Validate(); err != nil {
+		return nil, err
+	}
+	return &Game{
+		ID:    fmt.Sprintf("g-%d", rand.Int63()),
+		Rules: rules,
+		Board: newBoard(rules.Rows, rules.Cols),
+		Turn:  1,
+		Moves: []Move{},
+	}, nil
+}
+
+func newBoard(rows, cols int) [][]int {
+	cells := make([]int, rows*cols)
+	b := make([][]int, rows)
+	for r := range b {
+		b[r] = cells[r*cols : (r+1)*cols : (r+1)*cols]
+	}
+	return b
+}
+
+// Clone returns a deep copy of the game, safe to mutate for simulations.
+func (g *Game) Clone() *Game {
+	c := *g
+	c.Board = g.copyBoard()
+	c.Moves = append([]Move{}, g.Moves...)
+	c.undone = append([]Move(nil), g.undone...)
+	c.Clock = g.Clock.clone()
+	return &c
+}
+
+func (g *Game) copyBoard() [][]int {
+	b := newBoard(g.Rules.Rows, g.Rules.Cols)
+	for r := range g.Board {
+		copy(b[r], g.Board[r])
+	}
+	return b
 }
 
 var ErrInvalidColumn = errors.New("invalid column")
 var ErrColumnFull = errors.New("column full")
 var ErrNotYourTurn = errors.New("not your turn")
+var ErrGameOver = errors.New("game is over")
+var ErrNothingToUndo = errors.New("no move to undo")
+var ErrNothingToRedo = errors.New("no move to redo")
+var ErrEndedOffBoard = errors.New("game did not end on the board, so its last move cannot be undone")
+var ErrHintLimit = errors.New("no hints left")
+
+// NextRow returns the row a disc dropped into column would land on, or -1
+// if the column is full or out of range.
+func (g *Game) NextRow(column int) int {
+	if column < 0 || column >= g.Rules.Cols {
+		return -1
+	}
+	for r := 0; r < g.Rules.Rows; r++ {
+		if g.Board[r][column] == 0 {
+			return r
+		}
+	}
+	return -1
+}
 
 // Drop attempts to drop a disc for player into column. Returns row index.
+// The move is appended to Moves and Finished/Winner are updated if it ends
+// the game. Any moves previously taken back with Undo are discarded.
 func (g *Game) Drop(column int, player int) (int, error) {
-	if column < 0 || column >= Cols {
+	if g.Finished {
+		return -1, ErrGameOver
+	}
+	if column < 0 || column >= g.Rules.Cols {
 		return -1, ErrInvalidColumn
 	}
 	if player != g.Turn {
 		return -1, ErrNotYourTurn
 	}
-	for r := 0; r < Rows; r++ {
-		if g.Board[r][column] == 0 {
-			g.Board[r][column] = player
-			// toggle turn
-			if !g.Finished {
-				if player == 1 {
-					g.Turn = 2
-				} else {
-					g.Turn = 1
-				}
-			}
-			return r, nil
-		}
+	now := time.Now().UTC()
+	if g.CheckFlag(now) {
+		return -1, ErrFlagFall
+	}
+	r := g.NextRow(column)
+	if r < 0 {
+		return -1, ErrColumnFull
 	}
-	return -1, ErrColumnFull
+	g.play(Move{Player: player, Column: column, Row: r, At: now})
+	g.undone = nil
+	g.Offer = nil // moving on lets any open offer lapse
+	return r, nil
+}
+
+// play places an already validated move and settles the result.
+func (g *Game) play(m Move) {
+	g.Board[m.Row][m.Column] = m.Player
+	g.Moves = append(g.Moves, m)
+	// toggle turn
+	if m.Player == 1 {
+		g.Turn = 2
+	} else {
+		g.Turn = 1
+	}
+	if g.CheckWin(m.Row, m.Column, m.Player) {
+		g.end(m.Player, EndLine)
+	} else if g.IsFull() {
+		g.end(0, EndBoardFull)
+	}
+	if g.Clock != nil && !g.Finished {
+		g.Clock.Switch(g.Turn, m.At)
+	}
+}
+
+// end finishes the game with winner, 0 for a draw, stopping the clock.
+func (g *Game) end(winner int, reason string) {
+	g.Finished = true
+	g.Winner = winner
+	g.Reason = reason
+	if g.Clock != nil {
+		g.Clock.Stop(time.Now())
+	}
+}
+
+// endOnBoard finishes a game built from a bare board if the board says it
+// is over: won by g.Winner's line, or drawn once it is full.
+func (g *Game) endOnBoard() {
+	switch {
+	case g.Winner != 0:
+		g.end(g.Winner, EndLine)
+	case g.IsFull():
+		g.end(0, EndBoardFull)
+	}
+}
+
+// Abandon ends the game as a loss for a player who left.
+func (g *Game) Abandon(player int) {
+	g.end(3-player, EndAbandoned)
+}
+
+// LastMove returns the most recent move, if any.
+func (g *Game) LastMove() (Move, bool) {
+	if len(g.Moves) == 0 {
+		return Move{}, false
+	}
+	return g.Moves[len(g.Moves)-1], true
+}
+
+// Undo takes back the last move, giving the turn back to whoever played it,
+// and lets any open offer lapse. A game that move won or drew on the board
+// is reopened, since no finished position can precede the final move; one
+// that ended any other way, by resignation or on time say, stays over. On a
+// timed game the clock runs for the player to move again from now, without
+// giving back the time taken over the move.
+func (g *Game) Undo() (Move, error) {
+	m, ok := g.LastMove()
+	if !ok {
+		return Move{}, ErrNothingToUndo
+	}
+	if g.Finished && g.Reason != EndLine && g.Reason != EndBoardFull {
+		return Move{}, ErrEndedOffBoard
+	}
+	g.Moves = g.Moves[:len(g.Moves)-1]
+	g.Board[m.Row][m.Column] = 0
+	g.Turn = m.Player
+	g.Finished = false
+	g.Winner = 0
+	g.Reason = ""
+	g.Offer = nil
+	g.undone = append(g.undone, m)
+	if g.Clock != nil {
+		now := time.Now()
+		g.Clock.Stop(now)
+		g.Clock.Start(g.Turn, now)
+	}
+	return m, nil
+}
+
+// Redo replays the most recently undone move, unless the game has ended
+// since. The move is played as of now, so on a timed game it is charged to
+// the clock like any other; if the player has run out of time meanwhile the
+// game is lost on time instead.
+func (g *Game) Redo() (Move, error) {
+	if g.Finished {
+		return Move{}, ErrGameOver
+	}
+	if len(g.undone) == 0 {
+		return Move{}, ErrNothingToRedo
+	}
+	now := time.Now().UTC()
+	if g.CheckFlag(now) {
+		return Move{}, ErrFlagFall
+	}
+	m := g.undone[len(g.undone)-1]
+	g.undone = g.undone[:len(g.undone)-1]
+	m.At = now
+	g.play(m)
+	return m, nil
+}
+
+// UseHint records that player asked for a hint, failing once they have
+// used HintLimit.
+func (g *Game) UseHint(player int) error {
+	if player < 1 || player > 2 {
+		return ErrNotYourTurn
+	}
+	if g.Hints[player-1] >= HintLimit {
+		return ErrHintLimit
+	}
+	g.Hints[player-1]++
+	return nil
+}
+
+// HintsLeft returns how many more hints player may ask for.
+func (g *Game) HintsLeft(player int) int {
+	if player < 1 || player > 2 {
+		return 0
+	}
+	return HintLimit - g.Hints[player-1]
+}
+
+// Assisted reports whether either player used a hint. Assisted games do
+// not count towards rankings.
+func (g *Game) Assisted() bool {
+	return g.Hints[0] > 0 || g.Hints[1] > 0
+}
+
+// Ranked reports whether the result counts towards rankings: the game is
+// not casual and nobody used a hint.
+func (g *Game) Ranked() bool {
+	return !g.Casual && !g.Assisted()
+}
+
+// CanRedo reports whether there is an undone move to replay.
+func (g *Game) CanRedo() bool {
+	return len(g.undone) > 0
 }
 
 func (g *Game) IsFull() bool {
-	for c := 0; c < Cols; c++ {
-		if g.Board[Rows-1][c] == 0 {
+	for c := 0; c < g.Rules.Cols; c++ {
+		if g.Board[g.Rules.Rows-1][c] == 0 {
 			return false
 		}
 	}
 	return true
 }
 
-// CheckWin checks whether placing at (r,c) for player produced a connect4.
+// CheckWin checks whether placing at (r,c) for player produced a line of
+// Rules.Connect discs.
 func (g *Game) CheckWin(r, c int, player int) bool {
 	if player == 0 {
 		return false
 	}
+	rows, cols := g.Rules.Rows, g.Rules.Cols
 	dirs := [][2]int{{1, 0}, {0, 1}, {1, 1}, {1, -1}}
 	for _, d := range dirs {
 		cnt := 1
 		// forward
 		rr, cc := r+d[0], c+d[1]
-		for rr >= 0 && rr < Rows && cc >= 0 && cc < Cols && g.Board[rr][cc] == player {
+		for rr >= 0 && rr < rows && cc >= 0 && cc < cols && g.Board[rr][cc] == player {
 			cnt++
 			rr += d[0]
 			cc += d[1]
 		}
 		// backward
 		rr, cc = r-d[0], c-d[1]
-		for rr >= 0 && rr < Rows && cc >= 0 && cc < Cols && g.Board[rr][cc] == player {
+		for rr >= 0 && rr < rows && cc >= 0 && cc < cols && g.Board[rr][cc] == player {
 			cnt++
 			rr -= d[0]
 			cc -= d[1]
 		}
-		if cnt >= 4 {
+		if cnt >= g.Rules.Connect {
 			return true
 		}
 	}
@@ -99,11 +371,43 @@ func (g *Game) CheckWin(r, c int, player int) bool {
 
 func (g *Game) String() string {
 	s := ""
-	for r := Rows - 1; r >= 0; r-- {
-		for c := 0; c < Cols; c++ {
+	for r := g.Rules.Rows - 1; r >= 0; r-- {
+		for c := 0; c < g.Rules.Cols; c++ {
 			s += fmt.Sprintf("%d", g.Board[r][c])
 		}
 		s += "\n"
 	}
 	return s
 }
+
+// UnmarshalJSON decodes a game and checks that the board matches its rules.
+// Payloads written before rules existed are assumed to use the defaults.
+func (g *Game) UnmarshalJSON(data []byte) error {
+	type plain Game
+	var p plain
+	if err := json.Unmarshal(data, &p); err != nil {
+		return err
+	}
+	if p.Rules == (Rules{}) {
+		p.Rules = DefaultRules()
+	}
+	if err := p.Rules.Validate(); err != nil {
+		return err
+	}
+	if p.Board == nil {
+		p.Board = newBoard(p.Rules.Rows, p.Rules.Cols)
+	}
+	if p.Moves == nil {
+		p.Moves = []Move{}
+	}
+	if len(p.Board) != p.Rules.Rows {
+		return fmt.Errorf("board has %d rows, rules say %d", len(p.Board), p.Rules.Rows)
+	}
+	for r, row := range p.Board {
+		if len(row) != p.Rules.Cols {
+			return fmt.Errorf("board row %d has %d columns, rules say %d", r, len(row), p.Rules.Cols)
+		}
+	}
+	*g = Game(p)
+	return nil
+}
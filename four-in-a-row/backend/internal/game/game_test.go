@@ -0,0 +1,108 @@
+package game
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestUndo(t *testing.T) {
+	tests := []struct {
+		name    string
+		moves   string
+		end     func(g *Game)
+		wantErr error
+	}{
+		{"nothing played", "", nil, ErrNothingToUndo},
+		{"ongoing", "443", nil, nil},
+		{"won by a line", "4343434", nil, nil},
+		{"open offer", "44", func(g *Game) { g.MakeOffer(1, OfferDraw) }, nil},
+		{"timed", "443", func(g *Game) { g.SetTimeControl(TimeControl{Initial: time.Minute}) }, nil},
+		{"timed, won by a line", "4343434", func(g *Game) { g.SetTimeControl(TimeControl{Initial: time.Minute}) }, nil},
+		{"timed, redone an hour later", "443", func(g *Game) {
+			g.SetTimeControl(TimeControl{Initial: time.Minute})
+			g.Moves[len(g.Moves)-1].At = time.Now().Add(-time.Hour)
+		}, nil},
+		{"resigned", "44", func(g *Game) { g.Resign(1) }, ErrEndedOffBoard},
+		{"abandoned", "443", func(g *Game) { g.Abandon(2) }, ErrEndedOffBoard},
+		{"timed out", "4", func(g *Game) { g.end(1, EndTimeout) }, ErrEndedOffBoard},
+		{"draw agreed", "44", func(g *Game) {
+			g.MakeOffer(1, OfferDraw)
+			g.Accept(2, OfferDraw)
+		}, ErrEndedOffBoard},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := loadMoves(t, tt.moves)
+			if tt.end != nil {
+				tt.end(g)
+			}
+			finished, winner, plies := g.Finished, g.Winner, len(g.Moves)
+			m, err := g.Undo()
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Undo() error = %v, want %v", err, tt.wantErr)
+				}
+				if g.Finished != finished || g.Winner != winner || len(g.Moves) != plies {
+					t.Errorf("failed Undo changed the game: finished %v, winner %d, %d moves", g.Finished, g.Winner, len(g.Moves))
+				}
+				if _, err := g.Redo(); err == nil {
+					t.Error("Redo() after a failed Undo succeeded")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if g.Finished || g.Winner != 0 || g.Reason != "" || g.Offer != nil {
+				t.Errorf("after Undo: finished %v, winner %d, reason %q, offer %v", g.Finished, g.Winner, g.Reason, g.Offer)
+			}
+			if len(g.Moves) != plies-1 || g.Turn != m.Player || g.Board[m.Row][m.Column] != 0 {
+				t.Errorf("after Undo: %d moves, turn %d, cell %d, want %d moves, turn %d, empty cell",
+					len(g.Moves), g.Turn, g.Board[m.Row][m.Column], plies-1, m.Player)
+			}
+			if g.Clock != nil && g.Clock.Running != g.Turn {
+				t.Errorf("after Undo: clock running for player %d, want %d", g.Clock.Running, g.Turn)
+			}
+			r, err := g.Redo()
+			if err != nil || r.Player != m.Player || r.Column != m.Column || r.Row != m.Row || g.Finished != finished || g.Winner != winner {
+				t.Errorf("Redo() = %+v, %v, finished %v, winner %d, want %+v, finished %v, winner %d",
+					r, err, g.Finished, g.Winner, m, finished, winner)
+			}
+			if g.Clock != nil && g.Clock.Remaining[m.Player-1] > g.Clock.Control.Initial {
+				t.Errorf("after Redo: player %d has %v left, more than the %v they started with",
+					m.Player, g.Clock.Remaining[m.Player-1], g.Clock.Control.Initial)
+			}
+		})
+	}
+}
+
+func TestRedoOutOfTime(t *testing.T) {
+	g := loadMoves(t, "443")
+	g.SetTimeControl(TimeControl{PerMove: time.Millisecond})
+	if _, err := g.Undo(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, err := g.Redo(); !errors.Is(err, ErrFlagFall) {
+		t.Fatalf("Redo() after the flag fell error = %v, want %v", err, ErrFlagFall)
+	}
+	if !g.Finished || g.Reason != EndTimeout || g.Winner != 2 || len(g.Moves) != 2 {
+		t.Errorf("after Redo: finished %v, reason %q, winner %d, %d moves, want lost on time by player 1 with 2 moves",
+			g.Finished, g.Reason, g.Winner, len(g.Moves))
+	}
+}
+
+func TestRedoAfterResign(t *testing.T) {
+	g := loadMoves(t, "443")
+	if _, err := g.Undo(); err != nil {
+		t.Fatal(err)
+	}
+	g.Resign(2)
+	if _, err := g.Redo(); !errors.Is(err, ErrGameOver) {
+		t.Errorf("Redo() on a resigned game error = %v, want %v", err, ErrGameOver)
+	}
+	if len(g.Moves) != 2 {
+		t.Errorf("Redo() played onto a resigned game: %d moves", len(g.Moves))
+	}
+}
@@ -0,0 +1,296 @@
+package game
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Text notation
+//
+// A game is written as a block of header tags followed by the moves as
+// 1-based column numbers, one after another:
+//
+//	[Player1 "alice"]
+//	[Player2 "bot"]
+//	[Result "1-0"]
+//	[Date "2025-10-20"]
+//	[Rows "6"]
+//	[Cols "7"]
+//	[Connect "4"]
+//	[Termination "line"]
+//
+//	4453444
+//
+// Boards with more than nine columns write the moves separated by spaces.
+// Result is "1-0", "0-1", "1/2-1/2" or "*" for an unfinished game. Missing
+// rule tags default to the classic 6x7 connect four. The optional
+// Termination tag says how a finished game ended, one of the End reasons;
+// it is what lets a resigned, forfeited or timed out game be read back.
+
+const (
+	ResultPlayer1 = "1-0"
+	ResultPlayer2 = "0-1"
+	ResultDraw    = "1/2-1/2"
+	ResultOngoing = "*"
+
+	notationDate = "2006-01-02"
+)
+
+var ErrBadNotation = errors.New("bad notation")
+var ErrResultMismatch = errors.New("result does not follow from the moves")
+
+// Record is a game in text notation.
+type Record struct {
+	Player1 string
+	Player2 string
+	Result  string
+	Date    time.Time
+	Rules   Rules
+	Columns []int  // 0-based columns in the order they were played
+	Reason  string // Termination tag, how the game ended; empty if not known
+}
+
+// NewRecord describes g, played by p1 and p2, in notation form.
+func NewRecord(g *Game, p1, p2 string, date time.Time) Record {
+	cols := make([]int, len(g.Moves))
+	for i, m := range g.Moves {
+		cols[i] = m.Column
+	}
+	return Record{
+		Player1: p1,
+		Player2: p2,
+		Result:  ResultOf(g.Finished, g.Winner),
+		Date:    date,
+		Rules:   g.Rules,
+		Columns: cols,
+		Reason:  g.Reason,
+	}
+}
+
+// ResultOf returns the result tag for a game state.
+func ResultOf(finished bool, winner int) string {
+	switch {
+	case !finished:
+		return ResultOngoing
+	case winner == 1:
+		return ResultPlayer1
+	case winner == 2:
+		return ResultPlayer2
+	default:
+		return ResultDraw
+	}
+}
+
+// String writes the record in text notation.
+func (rec Record) String() string {
+	var b strings.Builder
+	tag := func(name, value string) {
+		fmt.Fprintf(&b, "[%s %q]\n", name, value)
+	}
+	tag("Player1", rec.Player1)
+	tag("Player2", rec.Player2)
+	result := rec.Result
+	if result == "" {
+		result = ResultOngoing
+	}
+	tag("Result", result)
+	if !rec.Date.IsZero() {
+		tag("Date", rec.Date.Format(notationDate))
+	}
+	rules := rec.Rules
+	if rules == (Rules{}) {
+		rules = DefaultRules()
+	}
+	tag("Rows", strconv.Itoa(rules.Rows))
+	tag("Cols", strconv.Itoa(rules.Cols))
+	tag("Connect", strconv.Itoa(rules.Connect))
+	if rec.Reason != "" {
+		tag("Termination", rec.Reason)
+	}
+	b.WriteString("\n")
+	sep := ""
+	if rules.Cols > 9 {
+		sep = " "
+	}
+	for i, c := range rec.Columns {
+		if i > 0 {
+			b.WriteString(sep)
+		}
+		b.WriteString(strconv.Itoa(c + 1))
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+// ParseRecord reads a game in text notation. Only the syntax is checked;
+// use Game to validate the moves.
+func ParseRecord(text string) (*Record, error) {
+	rec := &Record{Result: ResultOngoing, Rules: DefaultRules()}
+	var moves []string
+	sc := bufio.NewScanner(strings.NewReader(text))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "[") {
+			moves = append(moves, strings.Fields(line)...)
+			continue
+		}
+		if len(moves) > 0 {
+			return nil, fmt.Errorf("%w: line %d: header after moves", ErrBadNotation, n)
+		}
+		name, value, err := parseTag(line)
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: %v", ErrBadNotation, n, err)
+		}
+		if err := rec.setTag(name, value); err != nil {
+			return nil, fmt.Errorf("%w: line %d: %v", ErrBadNotation, n, err)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if err := rec.Rules.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadNotation, err)
+	}
+	cols, err := ParseMoves(rec.Rules, strings.Join(moves, " "))
+	if err != nil {
+		return nil, err
+	}
+	rec.Columns = cols
+	return rec, nil
+}
+
+// ParseMoves reads the move part of the notation, 1-based columns such as
+// "4453", and returns the 0-based columns. Moves are not played, so a full
+// column is not noticed.
+func ParseMoves(rules Rules, text string) ([]int, error) {
+	var cols []int
+	for _, tok := range strings.Fields(text) {
+		// a run of digits on a board of up to nine columns is one move per digit
+		digits := []string{tok}
+		if rules.Cols <= 9 {
+			digits = strings.Split(tok, "")
+		}
+		for _, d := range digits {
+			c, err := strconv.Atoi(d)
+			if err != nil || c < 1 || c > rules.Cols {
+				return nil, fmt.Errorf("%w: bad move %q", ErrBadNotation, d)
+			}
+			cols = append(cols, c-1)
+		}
+	}
+	return cols, nil
+}
+
+func parseTag(line string) (string, string, error) {
+	if !strings.HasSuffix(line, "]") {
+		return "", "", fmt.Errorf("unterminated tag %q", line)
+	}
+	body := strings.TrimSpace(line[1 : len(line)-1])
+	name, quoted, ok := strings.Cut(body, " ")
+	if !ok {
+		return "", "", fmt.Errorf("tag %q has no value", line)
+	}
+	value, err := strconv.Unquote(strings.TrimSpace(quoted))
+	if err != nil {
+		return "", "", fmt.Errorf("tag %s: value must be quoted", name)
+	}
+	return name, value, nil
+}
+
+func (rec *Record) setTag(name, value string) error {
+	var err error
+	switch name {
+	case "Player1":
+		rec.Player1 = value
+	case "Player2":
+		rec.Player2 = value
+	case "Result":
+		switch value {
+		case ResultPlayer1, ResultPlayer2, ResultDraw, ResultOngoing:
+			rec.Result = value
+		default:
+			return fmt.Errorf("unknown result %q", value)
+		}
+	case "Date":
+		rec.Date, err = time.Parse(notationDate, value)
+	case "Rows":
+		rec.Rules.Rows, err = strconv.Atoi(value)
+	case "Cols":
+		rec.Rules.Cols, err = strconv.Atoi(value)
+	case "Connect":
+		rec.Rules.Connect, err = strconv.Atoi(value)
+	case "Termination":
+		switch value {
+		case EndLine, EndBoardFull, EndTimeout, EndAbandoned, EndResigned, EndDrawAgreed:
+			rec.Reason = value
+		default:
+			return fmt.Errorf("unknown termination %q", value)
+		}
+	default:
+		// unknown tags are ignored so other tools can add their own
+	}
+	if err != nil {
+		return fmt.Errorf("tag %s: %v", name, err)
+	}
+	return nil
+}
+
+// Game plays the recorded moves through Drop, so every move is checked, and
+// returns the resulting game. The result must be the one the moves produce,
+// unless the Termination tag says the game ended off the board: a decisive
+// result on an unfinished board needs a resignation, forfeit or timeout,
+// and a draw there is taken as agreed unless it came on time.
+func (rec *Record) Game() (*Game, error) {
+	g, err := NewGameWithRules(rec.Rules)
+	if err != nil {
+		return nil, err
+	}
+	for i, c := range rec.Columns {
+		if _, err := g.Drop(c, g.Turn); err != nil {
+			return nil, fmt.Errorf("move %d (column %d): %w", i+1, c+1, err)
+		}
+	}
+	if g.Finished {
+		if rec.Result != ResultOngoing && rec.Result != ResultOf(true, g.Winner) {
+			return nil, fmt.Errorf("%w: result %s does not match the board (%s)", ErrResultMismatch, rec.Result, ResultOf(true, g.Winner))
+		}
+		if rec.Reason != "" && rec.Reason != g.Reason {
+			return nil, fmt.Errorf("%w: termination %s but the board ended by %s", ErrResultMismatch, rec.Reason, g.Reason)
+		}
+		return g, nil
+	}
+	switch rec.Result {
+	case ResultPlayer1, ResultPlayer2:
+		switch rec.Reason {
+		case EndResigned, EndAbandoned, EndTimeout:
+		default:
+			return nil, fmt.Errorf("%w: result %s but the moves do not win the game", ErrResultMismatch, rec.Result)
+		}
+		winner := 1
+		if rec.Result == ResultPlayer2 {
+			winner = 2
+		}
+		g.end(winner, rec.Reason)
+	case ResultDraw:
+		switch rec.Reason {
+		case "", EndDrawAgreed:
+			g.end(0, EndDrawAgreed)
+		case EndTimeout:
+			g.end(0, EndTimeout)
+		default:
+			return nil, fmt.Errorf("%w: a draw by %s on an unfinished board", ErrResultMismatch, rec.Reason)
+		}
+	default:
+		if rec.Reason != "" {
+			return nil, fmt.Errorf("%w: termination %s for an unfinished game", ErrResultMismatch, rec.Reason)
+		}
+	}
+	return g, nil
+}
@@ -0,0 +1,136 @@
+package game
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRecordRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		rec  Record
+	}{
+		{"empty", Record{Player1: "alice", Player2: "bob", Result: ResultOngoing, Rules: DefaultRules(), Columns: []int{}}},
+		{"win", Record{Player1: "alice", Player2: "bot", Result: ResultPlayer1, Rules: DefaultRules(), Columns: []int{3, 3, 2, 4, 3, 3, 3}}},
+		{"dated", Record{Player1: "a", Player2: "b", Result: ResultDraw, Date: time.Date(2025, 10, 20, 0, 0, 0, 0, time.UTC), Rules: DefaultRules(), Columns: []int{0, 6}}},
+		{"wide board", Record{Player1: "a", Player2: "b", Result: ResultOngoing, Rules: Rules{Rows: 8, Cols: 12, Connect: 5}, Columns: []int{11, 0, 9, 10}}},
+		{"terminated", Record{Player1: "a", Player2: "b", Result: ResultPlayer1, Rules: DefaultRules(), Columns: []int{3, 3}, Reason: EndResigned}},
+		{"quoted names", Record{Player1: `a "b"`, Player2: "c\\d", Result: ResultPlayer2, Rules: DefaultRules(), Columns: []int{1}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseRecord(tt.rec.String())
+			if err != nil {
+				t.Fatalf("ParseRecord: %v\n%s", err, tt.rec.String())
+			}
+			if len(got.Columns) == 0 {
+				got.Columns = []int{}
+			}
+			if !reflect.DeepEqual(*got, tt.rec) {
+				t.Errorf("round trip = %+v, want %+v", *got, tt.rec)
+			}
+		})
+	}
+}
+
+func TestParseRecordErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+	}{
+		{"unknown result", "[Result \"2-0\"]\n\n44"},
+		{"unquoted tag", "[Player1 alice]\n\n44"},
+		{"unterminated tag", "[Player1 \"alice\"\n\n44"},
+		{"header after moves", "44\n[Result \"*\"]"},
+		{"column out of range", "448"},
+		{"column zero", "40"},
+		{"bad rules", "[Rows \"2\"]\n\n1"},
+		{"bad date", "[Date \"20 Oct 2025\"]\n\n1"},
+		{"unknown termination", "[Termination \"bored\"]\n\n1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseRecord(tt.text); !errors.Is(err, ErrBadNotation) {
+				t.Errorf("ParseRecord(%q) error = %v, want %v", tt.text, err, ErrBadNotation)
+			}
+		})
+	}
+}
+
+func TestRecordGame(t *testing.T) {
+	tests := []struct {
+		name    string
+		result  string
+		term    string
+		moves   string
+		winner  int
+		reason  string
+		wantErr error
+	}{
+		{"line", ResultPlayer1, "", "4343434", 1, EndLine, nil},
+		{"line tagged", ResultPlayer1, EndLine, "4343434", 1, EndLine, nil},
+		{"line without result", ResultOngoing, "", "4343434", 1, EndLine, nil},
+		{"unfinished", ResultOngoing, "", "4343", 0, "", nil},
+		{"agreed draw", ResultDraw, "", "4343", 0, EndDrawAgreed, nil},
+		{"draw on time", ResultDraw, EndTimeout, "4343", 0, EndTimeout, nil},
+		{"resigned", ResultPlayer2, EndResigned, "44", 2, EndResigned, nil},
+		{"resigned at once", ResultPlayer1, EndResigned, "", 1, EndResigned, nil},
+		{"abandoned", ResultPlayer1, EndAbandoned, "443", 1, EndAbandoned, nil},
+		{"timeout", ResultPlayer2, EndTimeout, "4", 2, EndTimeout, nil},
+		{"wrong winner", ResultPlayer2, "", "4343434", 0, "", ErrResultMismatch},
+		{"wrong termination", ResultPlayer1, EndResigned, "4343434", 0, "", ErrResultMismatch},
+		{"win without moves", ResultPlayer1, "", "", 0, "", ErrResultMismatch},
+		{"win the moves do not make", ResultPlayer2, "", "4343", 0, "", ErrResultMismatch},
+		{"win by a line not on the board", ResultPlayer2, EndLine, "4343", 0, "", ErrResultMismatch},
+		{"resigned draw", ResultDraw, EndResigned, "4343", 0, "", ErrResultMismatch},
+		{"unfinished but terminated", ResultOngoing, EndResigned, "4343", 0, "", ErrResultMismatch},
+		{"full column", ResultOngoing, "", "4444444", 0, "", ErrColumnFull},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cols, err := ParseMoves(DefaultRules(), tt.moves)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rec := Record{Result: tt.result, Rules: DefaultRules(), Columns: cols, Reason: tt.term}
+			g, err := rec.Game()
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Game() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Game() error = %v", err)
+			}
+			if got := ResultOf(g.Finished, g.Winner); tt.result != ResultOngoing && got != tt.result {
+				t.Errorf("result = %s, want %s", got, tt.result)
+			}
+			if g.Winner != tt.winner || g.Reason != tt.reason {
+				t.Errorf("winner, reason = %d, %q, want %d, %q", g.Winner, g.Reason, tt.winner, tt.reason)
+			}
+		})
+	}
+}
+
+func TestResignedGameRoundTrip(t *testing.T) {
+	g := loadMoves(t, "44")
+	if err := g.Resign(1); err != nil {
+		t.Fatal(err)
+	}
+	text := NewRecord(g, "alice", "bob", time.Time{}).String()
+	rec, err := ParseRecord(text)
+	if err != nil {
+		t.Fatalf("ParseRecord: %v\n%s", err, text)
+	}
+	back, err := rec.Game()
+	if err != nil {
+		t.Fatalf("Game: %v\n%s", err, text)
+	}
+	if !back.Finished || back.Winner != g.Winner || back.Reason != EndResigned || len(back.Moves) != len(g.Moves) {
+		t.Errorf("read back finished %v, winner %d, reason %q, %d moves, want winner %d by %s after %d moves",
+			back.Finished, back.Winner, back.Reason, len(back.Moves), g.Winner, EndResigned, len(g.Moves))
+	}
+}
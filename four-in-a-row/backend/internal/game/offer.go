@@ -0,0 +1,109 @@
+package game
+
+import "errors"
+
+// Kinds of offer one player can make to the other.
+const (
+	OfferDraw     = "draw"
+	OfferTakeback = "takeback"
+)
+
+// Offer is a proposal waiting for the opponent's answer. At most one is
+// open at a time, and it lapses as soon as either player drops a disc.
+type Offer struct {
+	Kind string `json:"kind"`
+	By   int    `json:"by"` // player who made it
+}
+
+var ErrOfferPending = errors.New("an offer is already open")
+var ErrNoOffer = errors.New("no such offer to answer")
+var ErrNothingToTakeBack = errors.New("no move of yours to take back")
+var ErrUnknownOffer = errors.New("unknown kind of offer")
+
+// Resign ends the game as a loss for player.
+func (g *Game) Resign(player int) error {
+	if g.Finished {
+		return ErrGameOver
+	}
+	if player != 1 && player != 2 {
+		return ErrNotYourTurn
+	}
+	g.Offer = nil
+	g.end(3-player, EndResigned)
+	return nil
+}
+
+// MakeOffer opens an offer of kind, OfferDraw or OfferTakeback, from
+// player. A takeback can only be asked for once the player has a move to
+// take back.
+func (g *Game) MakeOffer(player int, kind string) error {
+	if g.Finished {
+		return ErrGameOver
+	}
+	if player != 1 && player != 2 {
+		return ErrNotYourTurn
+	}
+	if kind != OfferDraw && kind != OfferTakeback {
+		return ErrUnknownOffer
+	}
+	if g.Offer != nil {
+		return ErrOfferPending
+	}
+	if kind == OfferTakeback && g.lastMoveBy(player) < 0 {
+		return ErrNothingToTakeBack
+	}
+	g.Offer = &Offer{Kind: kind, By: player}
+	return nil
+}
+
+// Accept answers the opponent's open offer of kind. An accepted draw ends
+// the game; an accepted takeback undoes moves until it is the requester's
+// turn again from before their last move, and clears the redo history.
+func (g *Game) Accept(player int, kind string) error {
+	if err := g.answerable(player, kind); err != nil {
+		return err
+	}
+	o := g.Offer
+	g.Offer = nil
+	switch o.Kind {
+	case OfferDraw:
+		g.end(0, EndDrawAgreed)
+	case OfferTakeback:
+		for n := len(g.Moves) - g.lastMoveBy(o.By); n > 0; n-- {
+			g.Undo()
+		}
+		g.undone = nil
+	}
+	return nil
+}
+
+// Decline turns down the opponent's open offer of kind.
+func (g *Game) Decline(player int, kind string) error {
+	if err := g.answerable(player, kind); err != nil {
+		return err
+	}
+	g.Offer = nil
+	return nil
+}
+
+// answerable checks that player has an offer of kind from the other player
+// waiting for them.
+func (g *Game) answerable(player int, kind string) error {
+	if g.Finished {
+		return ErrGameOver
+	}
+	if g.Offer == nil || g.Offer.Kind != kind || g.Offer.By == player || (player != 1 && player != 2) {
+		return ErrNoOffer
+	}
+	return nil
+}
+
+// lastMoveBy returns the index in Moves of player's latest move, or -1.
+func (g *Game) lastMoveBy(player int) int {
+	for i := len(g.Moves) - 1; i >= 0; i-- {
+		if g.Moves[i].Player == player {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,119 @@
+package game
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTakeback(t *testing.T) {
+	tests := []struct {
+		name    string
+		moves   string
+		by      int
+		plies   int // moves left once accepted
+		turn    int
+		wantErr error
+	}{
+		{"own move, opponent to move", "4", 1, 0, 1, nil},
+		{"own move and the reply", "44", 1, 0, 1, nil},
+		{"player 2, own move", "44", 2, 1, 2, nil},
+		{"player 2, own move and the reply", "443", 2, 1, 2, nil},
+		{"deeper in the game", "4433226", 2, 5, 2, nil},
+		{"nothing played", "", 1, 0, 0, ErrNothingToTakeBack},
+		{"no move of player 2", "4", 2, 0, 0, ErrNothingToTakeBack},
+		{"game over", "4343434", 2, 0, 0, ErrGameOver},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := loadMoves(t, tt.moves)
+			err := g.MakeOffer(tt.by, OfferTakeback)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("MakeOffer() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := g.Accept(tt.by, OfferTakeback); !errors.Is(err, ErrNoOffer) {
+				t.Errorf("accepting own offer: error = %v, want %v", err, ErrNoOffer)
+			}
+			if err := g.Accept(3-tt.by, OfferTakeback); err != nil {
+				t.Fatal(err)
+			}
+			if len(g.Moves) != tt.plies || g.Turn != tt.turn {
+				t.Errorf("after takeback: %d moves, turn %d, want %d, %d", len(g.Moves), g.Turn, tt.plies, tt.turn)
+			}
+			if g.Offer != nil || g.CanRedo() {
+				t.Errorf("after takeback: offer %v, can redo %v", g.Offer, g.CanRedo())
+			}
+			want := loadMoves(t, tt.moves[:tt.plies])
+			for r := range want.Board {
+				for c := range want.Board[r] {
+					if g.Board[r][c] != want.Board[r][c] {
+						t.Fatalf("board after takeback:\n%s\nwant\n%s", g, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestOffers(t *testing.T) {
+	tests := []struct {
+		name   string
+		steps  func(g *Game) error
+		want   error
+		reason string
+	}{
+		{"draw agreed", func(g *Game) error {
+			g.MakeOffer(1, OfferDraw)
+			return g.Accept(2, OfferDraw)
+		}, nil, EndDrawAgreed},
+		{"draw declined", func(g *Game) error {
+			g.MakeOffer(2, OfferDraw)
+			return g.Decline(1, OfferDraw)
+		}, nil, ""},
+		{"second offer", func(g *Game) error {
+			g.MakeOffer(1, OfferDraw)
+			return g.MakeOffer(2, OfferTakeback)
+		}, ErrOfferPending, ""},
+		{"wrong kind", func(g *Game) error {
+			g.MakeOffer(1, OfferDraw)
+			return g.Accept(2, OfferTakeback)
+		}, ErrNoOffer, ""},
+		{"lapses on a move", func(g *Game) error {
+			g.MakeOffer(2, OfferDraw)
+			g.Drop(0, g.Turn)
+			return g.Accept(1, OfferDraw)
+		}, ErrNoOffer, ""},
+		{"resign", func(g *Game) error {
+			return g.Resign(1)
+		}, nil, EndResigned},
+		{"resign twice", func(g *Game) error {
+			g.Resign(1)
+			return g.Resign(2)
+		}, ErrGameOver, EndResigned},
+		{"not a player", func(g *Game) error {
+			return g.MakeOffer(3, OfferDraw)
+		}, ErrNotYourTurn, ""},
+		{"unknown kind", func(g *Game) error {
+			if err := g.MakeOffer(1, "anything"); err != nil {
+				return err
+			}
+			return g.MakeOffer(1, OfferDraw)
+		}, ErrUnknownOffer, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := loadMoves(t, "44")
+			if err := tt.steps(g); !errors.Is(err, tt.want) {
+				t.Fatalf("error = %v, want %v", err, tt.want)
+			}
+			if g.Reason != tt.reason || g.Finished != (tt.reason != "") {
+				t.Errorf("finished %v, reason %q, want reason %q", g.Finished, g.Reason, tt.reason)
+			}
+		})
+	}
+}
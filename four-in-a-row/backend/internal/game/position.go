@@ -0,0 +1,308 @@
+package game
+
+import (
+	"errors"
+	"math/bits"
+)
+
+// Position is a compact bitboard form of a game used by the search bots.
+//
+// Each column takes Rows+1 bits, bottom cell first, with the extra bit kept
+// empty as a separator so that shifting a line never wraps into the next
+// column. Cell (row, col) is bit col*(Rows+1)+row. current holds the discs
+// of the player to move and mask holds every disc, so the opponent's discs
+// are current^mask. Boards only fit when Cols*(Rows+1) <= 64.
+type Position struct {
+	current uint64
+	mask    uint64
+	bottom  uint64         // bottom cell of every column
+	board   uint64         // every cell of the board
+	height  [MaxSize]uint8 // discs in each column
+	plies   int
+	rows    int
+	cols    int
+	connect int
+}
+
+var ErrBoardTooLarge = errors.New("board too large for a bitboard")
+
+// Fits reports whether boards with these rules can be held in a Position.
+func Fits(r Rules) bool {
+	return r.Cols*(r.Rows+1) <= 64
+}
+
+// NewPosition returns the empty position for the rules.
+func NewPosition(r Rules) (*Position, error) {
+	if err := r.Validate(); err != nil {
+		return nil, err
+	}
+	if !Fits(r) {
+		return nil, ErrBoardTooLarge
+	}
+	p := &Position{rows: r.Rows, cols: r.Cols, connect: r.Connect}
+	for c := 0; c < p.cols; c++ {
+		p.bottom |= p.bit(0, c)
+		p.board |= p.Column(c)
+	}
+	return p, nil
+}
+
+// PositionOf converts a game to a bitboard. The move history is not kept.
+func PositionOf(g *Game) (*Position, error) {
+	p, err := NewPosition(g.Rules)
+	if err != nil {
+		return nil, err
+	}
+	var p1, p2 uint64
+	for c := 0; c < p.cols; c++ {
+		for r := 0; r < p.rows; r++ {
+			switch g.Board[r][c] {
+			case 0:
+				continue
+			case 1:
+				p1 |= p.bit(r, c)
+			default:
+				p2 |= p.bit(r, c)
+			}
+			p.height[c]++
+			p.plies++
+		}
+	}
+	p.mask = p1 | p2
+	p.current = p1
+	if g.Turn == 2 {
+		p.current = p2
+	}
+	return p, nil
+}
+
+// Game converts the position back to a game, with Turn, Finished and
+// Winner filled in from the board. Moves is empty since a bitboard does
+// not record the order the discs were played in.
+func (p *Position) Game() *Game {
+	g, _ := NewGameWithRules(p.Rules())
+	for c := 0; c < p.cols; c++ {
+		for r := 0; r < p.rows; r++ {
+			if b := p.bit(r, c); p.mask&b != 0 {
+				g.Board[r][c] = p.owner(b)
+			}
+		}
+	}
+	g.Turn = p.Turn()
+	g.Winner = p.Winner()
+	g.endOnBoard()
+	return g
+}
+
+func (p *Position) Rules() Rules {
+	return Rules{Rows: p.rows, Cols: p.cols, Connect: p.connect}
+}
+
+func (p *Position) bit(row, col int) uint64 {
+	return 1 << uint(col*(p.rows+1)+row)
+}
+
+func (p *Position) owner(b uint64) int {
+	if (p.current&b != 0) == (p.plies%2 == 0) {
+		return 1
+	}
+	return 2
+}
+
+// Turn returns the player to move, 1 or 2.
+func (p *Position) Turn() int {
+	return p.plies%2 + 1
+}
+
+// Plies returns the number of discs on the board.
+func (p *Position) Plies() int {
+	return p.plies
+}
+
+// Full reports whether every cell is taken.
+func (p *Position) Full() bool {
+	return p.plies == p.rows*p.cols
+}
+
+// Discs returns the bitboard of one player's discs.
+func (p *Position) Discs(player int) uint64 {
+	if player == p.Turn() {
+		return p.current
+	}
+	return p.current ^ p.mask
+}
+
+// CanPlay reports whether col is on the board and not full.
+func (p *Position) CanPlay(col int) bool {
+	return col >= 0 && col < p.cols && int(p.height[col]) < p.rows
+}
+
+// LegalColumns appends the playable columns to dst.
+func (p *Position) LegalColumns(dst []int) []int {
+	for c := 0; c < p.cols; c++ {
+		if int(p.height[c]) < p.rows {
+			dst = append(dst, c)
+		}
+	}
+	return dst
+}
+
+// Play drops a disc for the player to move. col must be playable.
+func (p *Position) Play(col int) {
+	p.current ^= p.mask
+	p.mask |= p.bit(int(p.height[col]), col)
+	p.height[col]++
+	p.plies++
+}
+
+// Undo takes back the top disc of col, which must be the last move played.
+func (p *Position) Undo(col int) {
+	p.height[col]--
+	p.plies--
+	p.mask &^= p.bit(int(p.height[col]), col)
+	p.current ^= p.mask
+}
+
+// IsWinningMove reports whether playing col wins for the player to move.
+func (p *Position) IsWinningMove(col int) bool {
+	return p.hasLine(p.current | p.bit(int(p.height[col]), col))
+}
+
+// WinsAt reports whether player would complete a line by dropping into col
+// next, whether or not it is their turn.
+func (p *Position) WinsAt(player, col int) bool {
+	return p.hasLine(p.Discs(player) | p.bit(int(p.height[col]), col))
+}
+
+// Possible returns the cells a disc can be dropped into next.
+func (p *Position) Possible() uint64 {
+	return (p.mask + p.bottom) & p.board
+}
+
+// WinningCells returns the empty cells where player would complete a line,
+// whether or not they can be played yet.
+func (p *Position) WinningCells(player int) uint64 {
+	return p.winningCells(p.Discs(player))
+}
+
+// CanWinNext reports whether the player to move has a winning move.
+func (p *Position) CanWinNext() bool {
+	return p.winningCells(p.current)&p.Possible() != 0
+}
+
+// NonLosingMoves returns the playable cells that do not let the opponent
+// win on their next move: a threat of theirs must be blocked, and a cell
+// directly below one of their winning cells must be avoided. It is zero if
+// every move loses. Only meaningful when the player to move cannot win at
+// once.
+func (p *Position) NonLosingMoves() uint64 {
+	possible := p.Possible()
+	theirs := p.winningCells(p.current ^ p.mask)
+	if forced := possible & theirs; forced != 0 {
+		if forced&(forced-1) != 0 {
+			return 0 // two threats cannot both be blocked
+		}
+		possible = forced
+	}
+	return possible &^ (theirs >> 1)
+}
+
+// MoveScore rates playing cell, one of the bits of Possible, by how many
+// winning cells it leaves the player to move. Searches try high scores first.
+func (p *Position) MoveScore(cell uint64) int {
+	return Count(p.winningCells(p.current | cell))
+}
+
+// winningCells finds every empty cell that completes a line with b. For
+// each direction, after[k] holds the cells followed by k discs of b and
+// before[k] the cells preceded by k discs; a cell wins if it has i discs on
+// one side and connect-1-i on the other.
+func (p *Position) winningCells(b uint64) uint64 {
+	h := uint(p.rows + 1)
+	n := p.connect
+	var after, before [MaxSize]uint64
+	after[0], before[0] = p.board, p.board
+	var w uint64
+	for _, s := range [4]uint{1, h, h + 1, h - 1} {
+		for k := 1; k < n; k++ {
+			after[k] = after[k-1] & (b >> (s * uint(k)))
+			before[k] = before[k-1] & (b << (s * uint(k)))
+		}
+		for i := 0; i < n; i++ {
+			w |= after[i] & before[n-1-i]
+		}
+	}
+	return w &^ p.mask
+}
+
+// Winner returns the player who has a line, or 0.
+func (p *Position) Winner() int {
+	if p.hasLine(p.current ^ p.mask) {
+		// only the player who just moved can have completed a line
+		return 3 - p.Turn()
+	}
+	if p.hasLine(p.current) {
+		return p.Turn()
+	}
+	return 0
+}
+
+// Key uniquely identifies the position among positions of the same rules.
+func (p *Position) Key() uint64 {
+	return p.current + p.mask
+}
+
+// hasLine reports whether b contains connect discs in a row. For each
+// direction, m keeps the first cell of every run of k discs; ANDing m with
+// itself shifted by k cells doubles k, so only a few shifts are needed.
+func (p *Position) hasLine(b uint64) bool {
+	h := uint(p.rows + 1)
+	n := uint(p.connect)
+	for _, s := range [4]uint{1, h, h + 1, h - 1} {
+		m, k := b, uint(1)
+		for 2*k <= n && m != 0 {
+			m &= m >> (s * k)
+			k *= 2
+		}
+		if k < n {
+			m &= m >> (s * (n - k))
+		}
+		if m != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Count returns the number of discs set in a bitboard.
+func Count(b uint64) int {
+	return bits.OnesCount64(b)
+}
+
+// Lines returns a bitboard for every line of connect cells on the board,
+// which evaluation functions use to count threats.
+func (p *Position) Lines() []uint64 {
+	var lines []uint64
+	dirs := [][2]int{{0, 1}, {1, 0}, {1, 1}, {1, -1}}
+	for r := 0; r < p.rows; r++ {
+		for c := 0; c < p.cols; c++ {
+			for _, d := range dirs {
+				er, ec := r+d[0]*(p.connect-1), c+d[1]*(p.connect-1)
+				if er < 0 || er >= p.rows || ec < 0 || ec >= p.cols {
+					continue
+				}
+				var line uint64
+				for i := 0; i < p.connect; i++ {
+					line |= p.bit(r+d[0]*i, c+d[1]*i)
+				}
+				lines = append(lines, line)
+			}
+		}
+	}
+	return lines
+}
+
+// Column returns the bitboard of every cell in col.
+func (p *Position) Column(col int) uint64 {
+	return (uint64(1)<<uint(p.rows) - 1) << uint(col*(p.rows+1))
+}
@@ -0,0 +1,295 @@
+package game
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// positionRules are boards that fit in a Position, with several connect
+// lengths so lines of every size are checked.
+var positionRules = []Rules{
+	DefaultRules(),
+	{Rows: 4, Cols: 4, Connect: 3},
+	{Rows: 5, Cols: 9, Connect: 5},
+	{Rows: 7, Cols: 8, Connect: 4},
+	{Rows: 4, Cols: 12, Connect: 6},
+}
+
+// TestPositionMatchesGame plays random games on the array board and the
+// bitboard side by side and checks they always agree on wins and moves.
+func TestPositionMatchesGame(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, rules := range positionRules {
+		for n := 0; n < 200; n++ {
+			g, err := NewGameWithRules(rules)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p, err := NewPosition(rules)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for !g.Finished {
+				comparePosition(t, g, p)
+				var legal []int
+				for c := 0; c < rules.Cols; c++ {
+					if g.NextRow(c) >= 0 {
+						legal = append(legal, c)
+					}
+				}
+				c := legal[rng.Intn(len(legal))]
+				if _, err := g.Drop(c, g.Turn); err != nil {
+					t.Fatalf("%v: drop %d: %v", rules, c, err)
+				}
+				p.Play(c)
+			}
+			if got := p.Winner(); got != g.Winner {
+				t.Fatalf("%v after %v: Winner() = %d, want %d\n%s", rules, g.Moves, got, g.Winner, g)
+			}
+			if got := p.Full(); got != g.IsFull() {
+				t.Fatalf("%v: Full() = %v, want %v", rules, got, g.IsFull())
+			}
+			if back := p.Game(); back.Finished != g.Finished || back.Winner != g.Winner || back.Reason != g.Reason {
+				t.Fatalf("%v: Game() finished %v, winner %d, reason %q, want %v, %d, %q",
+					rules, back.Finished, back.Winner, back.Reason, g.Finished, g.Winner, g.Reason)
+			}
+		}
+	}
+}
+
+// comparePosition checks every column of an unfinished game against p.
+func comparePosition(t *testing.T, g *Game, p *Position) {
+	t.Helper()
+	if p.Turn() != g.Turn || p.Plies() != len(g.Moves) || p.Winner() != 0 {
+		t.Fatalf("turn, plies, winner = %d, %d, %d, want %d, %d, 0\n%s", p.Turn(), p.Plies(), p.Winner(), g.Turn, len(g.Moves), g)
+	}
+	var legal []int
+	for c := 0; c < g.Rules.Cols; c++ {
+		r := g.NextRow(c)
+		if p.CanPlay(c) != (r >= 0) {
+			t.Fatalf("CanPlay(%d) = %v with next row %d\n%s", c, p.CanPlay(c), r, g)
+		}
+		if r < 0 {
+			continue
+		}
+		legal = append(legal, c)
+		for _, player := range []int{1, 2} {
+			g.Board[r][c] = player
+			want := g.CheckWin(r, c, player)
+			g.Board[r][c] = 0
+			if got := p.WinsAt(player, c); got != want {
+				t.Fatalf("WinsAt(%d, %d) = %v, want %v\n%s", player, c, got, want, g)
+			}
+			if player == g.Turn && p.IsWinningMove(c) != want {
+				t.Fatalf("IsWinningMove(%d) = %v, want %v\n%s", c, !want, want, g)
+			}
+		}
+	}
+	if got := p.LegalColumns(nil); !reflect.DeepEqual(got, legal) {
+		t.Fatalf("LegalColumns() = %v, want %v\n%s", got, legal, g)
+	}
+	back := p.Game()
+	if !reflect.DeepEqual(back.Board, g.Board) {
+		t.Fatalf("Game() board differs:\n%s\nwant\n%s", back, g)
+	}
+}
+
+func TestPositionOf(t *testing.T) {
+	tests := []struct {
+		name   string
+		moves  string
+		winner int
+	}{
+		{"empty", "", 0},
+		{"vertical", "4343434", 1},
+		{"horizontal", "1122334", 1},
+		{"no line", "1223343447", 0},
+		{"rising diagonal", "12233434474", 1},
+		{"falling diagonal", "76655454414", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := loadMoves(t, tt.moves)
+			p, err := PositionOf(g)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.Winner() != tt.winner || g.Winner != tt.winner {
+				t.Errorf("Winner() = %d, game winner %d, want %d\n%s", p.Winner(), g.Winner, tt.winner, g)
+			}
+			if p.Turn() != g.Turn || p.Plies() != len(g.Moves) {
+				t.Errorf("turn, plies = %d, %d, want %d, %d", p.Turn(), p.Plies(), g.Turn, len(g.Moves))
+			}
+		})
+	}
+}
+
+func TestPositionTooLarge(t *testing.T) {
+	g, err := NewGameWithRules(Rules{Rows: 12, Cols: 12, Connect: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := PositionOf(g); err != ErrBoardTooLarge {
+		t.Errorf("PositionOf 12x12 error = %v, want %v", err, ErrBoardTooLarge)
+	}
+}
+
+func loadMoves(tb testing.TB, moves string) *Game {
+	tb.Helper()
+	g := NewGame()
+	for _, ch := range moves {
+		if _, err := g.Drop(int(ch-'1'), g.Turn); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	return g
+}
+
+// midgame is a 6x7 position the benchmarks work from, 1-based columns.
+const midgame = "4453344256"
+
+func benchPositions(b *testing.B) (*Game, *Position) {
+	g := loadMoves(b, midgame)
+	p, err := PositionOf(g)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return g, p
+}
+
+func BenchmarkWinCheck(b *testing.B) {
+	g, p := benchPositions(b)
+	cols := g.Rules.Cols
+	b.Run("array", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for c := 0; c < cols; c++ {
+				r := g.NextRow(c)
+				if r < 0 {
+					continue
+				}
+				g.Board[r][c] = g.Turn
+				g.CheckWin(r, c, g.Turn)
+				g.Board[r][c] = 0
+			}
+		}
+	})
+	b.Run("bitboard", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for c := 0; c < cols; c++ {
+				if p.CanPlay(c) {
+					p.IsWinningMove(c)
+				}
+			}
+		}
+	})
+}
+
+func BenchmarkMoveGeneration(b *testing.B) {
+	g, p := benchPositions(b)
+	cols := g.Rules.Cols
+	b.Run("array", func(b *testing.B) {
+		var moves []int
+		for i := 0; i < b.N; i++ {
+			moves = moves[:0]
+			for c := 0; c < cols; c++ {
+				if g.NextRow(c) >= 0 {
+					moves = append(moves, c)
+				}
+			}
+		}
+	})
+	b.Run("bitboard", func(b *testing.B) {
+		var moves []int
+		for i := 0; i < b.N; i++ {
+			moves = p.LegalColumns(moves[:0])
+		}
+	})
+}
+
+// BenchmarkSimulate is what the bots did per candidate move before the
+// bitboard: copy the game, drop, check.
+func BenchmarkSimulate(b *testing.B) {
+	g, p := benchPositions(b)
+	cols := g.Rules.Cols
+	b.Run("array", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for c := 0; c < cols; c++ {
+				sim := g.Clone()
+				if r, err := sim.Drop(c, sim.Turn); err == nil {
+					sim.CheckWin(r, c, 3-sim.Turn)
+				}
+			}
+		}
+	})
+	b.Run("bitboard", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for c := 0; c < cols; c++ {
+				if p.CanPlay(c) {
+					p.Play(c)
+					p.Winner()
+					p.Undo(c)
+				}
+			}
+		}
+	})
+}
+
+func BenchmarkPerft5(b *testing.B) {
+	g, p := benchPositions(b)
+	b.Run("array", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			perftGame(g.Clone(), 5)
+		}
+	})
+	b.Run("bitboard", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			perftPosition(p, 5)
+		}
+	})
+}
+
+// perftGame counts the positions reachable in depth moves.
+func perftGame(g *Game, depth int) int {
+	if depth == 0 || g.Finished {
+		return 1
+	}
+	n := 0
+	for c := 0; c < g.Rules.Cols; c++ {
+		if _, err := g.Drop(c, g.Turn); err != nil {
+			continue
+		}
+		n += perftGame(g, depth-1)
+		g.Undo()
+	}
+	return n
+}
+
+func perftPosition(p *Position, depth int) int {
+	if depth == 0 || p.Winner() != 0 {
+		return 1
+	}
+	n := 0
+	for c := 0; c < p.Rules().Cols; c++ {
+		if !p.CanPlay(c) {
+			continue
+		}
+		p.Play(c)
+		n += perftPosition(p, depth-1)
+		p.Undo(c)
+	}
+	return n
+}
+
+func TestPerftAgrees(t *testing.T) {
+	g := loadMoves(t, midgame)
+	p, err := PositionOf(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for depth := 1; depth <= 4; depth++ {
+		if a, b := perftGame(g.Clone(), depth), perftPosition(p, depth); a != b {
+			t.Errorf("perft %d: array %d, bitboard %d", depth, a, b)
+		}
+	}
+}
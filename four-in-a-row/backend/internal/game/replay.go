@@ -0,0 +1,57 @@
+package game
+
+import "fmt"
+
+// Step is the state of a game after a number of moves have been played.
+type Step struct {
+	Ply      int     `json:"ply"`            // number of moves played so far
+	Move     *Move   `json:"move,omitempty"` // the move that led here, nil for the empty board
+	Board    [][]int `json:"board"`
+	Turn     int     `json:"turn"`
+	Finished bool    `json:"finished"`
+	Winner   int     `json:"winner"`
+	Reason   string  `json:"reason,omitempty"` // why the game ended, set once it has
+}
+
+// Replay plays moves from an empty board, validating each one, and returns
+// the position before the first move and after every move. winner and
+// reason are the stored result: a game that ended off the board, by
+// resignation or on time say, is shown as over with that result after its
+// last move.
+func Replay(rules Rules, moves []Move, winner int, reason string) ([]Step, error) {
+	g, err := NewGameWithRules(rules)
+	if err != nil {
+		return nil, err
+	}
+	steps := make([]Step, 0, len(moves)+1)
+	steps = append(steps, g.step(nil))
+	for i, m := range moves {
+		row, err := g.Drop(m.Column, m.Player)
+		if err != nil {
+			return steps, fmt.Errorf("move %d (column %d): %w", i+1, m.Column, err)
+		}
+		if row != m.Row {
+			return steps, fmt.Errorf("move %d (column %d): landed on row %d, recorded row %d", i+1, m.Column, row, m.Row)
+		}
+		m := m
+		steps = append(steps, g.step(&m))
+	}
+	if !g.Finished && reason != "" {
+		g.end(winner, reason)
+		last := &steps[len(steps)-1]
+		*last = g.step(last.Move)
+	}
+	return steps, nil
+}
+
+func (g *Game) step(m *Move) Step {
+	return Step{
+		Ply:      len(g.Moves),
+		Move:     m,
+		Board:    g.copyBoard(),
+		Turn:     g.Turn,
+		Finished: g.Finished,
+		Winner:   g.Winner,
+		Reason:   g.Reason,
+	}
+}
@@ -0,0 +1,41 @@
+package game
+
+import "testing"
+
+func TestReplayResult(t *testing.T) {
+	tests := []struct {
+		name       string
+		moves      string
+		winner     int
+		reason     string
+		wantWinner int
+	}{
+		{"ongoing", "443", 0, "", 0},
+		{"won by a line", "4343434", 1, EndLine, 1},
+		{"resigned", "443", 1, EndResigned, 1},
+		{"timed out", "44", 2, EndTimeout, 2},
+		{"draw agreed", "4433", 0, EndDrawAgreed, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := loadMoves(t, tt.moves)
+			steps, err := Replay(g.Rules, g.Moves, tt.winner, tt.reason)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(steps) != len(g.Moves)+1 {
+				t.Fatalf("Replay returned %d steps, want %d", len(steps), len(g.Moves)+1)
+			}
+			for _, s := range steps[:len(steps)-1] {
+				if s.Finished || s.Reason != "" {
+					t.Errorf("step %d: finished %v, reason %q before the last move", s.Ply, s.Finished, s.Reason)
+				}
+			}
+			last := steps[len(steps)-1]
+			if last.Finished != (tt.reason != "") || last.Winner != tt.wantWinner || last.Reason != tt.reason {
+				t.Errorf("last step: finished %v, winner %d, reason %q, want finished %v, winner %d, reason %q",
+					last.Finished, last.Winner, last.Reason, tt.reason != "", tt.wantWinner, tt.reason)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package game
+
+import "math/rand"
+
+// Zobrist hashing gives every (player, row, col) a random 64-bit value; a
+// board's hash is the XOR of the values of its discs, so it can be updated
+// one move at a time. The table is generated from a fixed seed so hashes
+// stay the same between runs and can be stored.
+var (
+	zobrist     [2][MaxSize][MaxSize]uint64
+	zobristSide uint64
+	zobristDim  [3][MaxSize + 1]uint64
+)
+
+func init() {
+	rng := rand.New(rand.NewSource(0x4c0ffee4))
+	for p := range zobrist {
+		for r := range zobrist[p] {
+			for c := range zobrist[p][r] {
+				zobrist[p][r][c] = rng.Uint64()
+			}
+		}
+	}
+	zobristSide = rng.Uint64()
+	for i := range zobristDim {
+		for n := range zobristDim[i] {
+			zobristDim[i][n] = rng.Uint64()
+		}
+	}
+}
+
+// ZobristKey returns the hash contribution of player's disc at (row, col).
+func ZobristKey(player, row, col int) uint64 {
+	return zobrist[player-1][row][col]
+}
+
+// ZobristSide is XORed in when player 2 is to move.
+func ZobristSide() uint64 {
+	return zobristSide
+}
+
+// Hash returns the Zobrist hash of the board and the player to move.
+func (g *Game) Hash() uint64 {
+	var h uint64
+	for r, row := range g.Board {
+		for c, v := range row {
+			if v != 0 {
+				h ^= ZobristKey(v, r, c)
+			}
+		}
+	}
+	if g.Turn == 2 {
+		h ^= zobristSide
+	}
+	return h
+}
+
+// Hash returns a value that differs between rule sets, for keeping positions
+// of different board sizes apart in one table.
+func (r Rules) Hash() uint64 {
+	return zobristDim[0][r.Rows] ^ zobristDim[1][r.Cols] ^ zobristDim[2][r.Connect]
+}
@@ -2,18 +2,55 @@
 CREATE TABLE IF NOT EXISTS users (
     id SERIAL PRIMARY KEY,
     username VARCHAR(50) UNIQUE NOT NULL,
+    password_hash TEXT,
     wins INT DEFAULT 0,
     losses INT DEFAULT 0,
-    games_played INT DEFAULT 0
+    games_played INT DEFAULT 0,
+    created_at TIMESTAMP DEFAULT now()
 );
 
 -- Games table
 CREATE TABLE IF NOT EXISTS games (
+    id TEXT PRIMARY KEY,
+    player1 TEXT,
+    player2 TEXT,
+    winner INT,
+    moves JSONB,
+    created_at TIMESTAMP DEFAULT now(),
+    rules JSONB,
+    hints JSONB,
+    ranked BOOLEAN DEFAULT true,
+    reason TEXT,
+    time_control TEXT,
+    imported BOOLEAN DEFAULT false
+);
+CREATE INDEX IF NOT EXISTS games_player1 ON games (player1);
+CREATE INDEX IF NOT EXISTS games_player2 ON games (player2);
+
+-- Leaderboard table
+CREATE TABLE IF NOT EXISTS leaderboard (
+    username TEXT PRIMARY KEY,
+    wins INT
+);
+
+-- Ratings table, one row per player and rating pool
+CREATE TABLE IF NOT EXISTS ratings (
+    username TEXT,
+    pool TEXT,
+    rating INT NOT NULL,
+    games INT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP DEFAULT now(),
+    PRIMARY KEY (username, pool)
+);
+
+-- Rating history table, one row per rating change
+CREATE TABLE IF NOT EXISTS rating_history (
     id SERIAL PRIMARY KEY,
-    player1 VARCHAR(50) NOT NULL,
-    player2 VARCHAR(50) NOT NULL,
-    winner VARCHAR(50),
-    start_time TIMESTAMP,
-    end_time TIMESTAMP,
-    duration INTERVAL
+    username TEXT NOT NULL,
+    pool TEXT NOT NULL,
+    game_id TEXT,
+    rating_before INT,
+    rating_after INT,
+    created_at TIMESTAMP DEFAULT now()
 );
+CREATE INDEX IF NOT EXISTS rating_history_user ON rating_history (username, pool, id);
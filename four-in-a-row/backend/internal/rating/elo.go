@@ -0,0 +1,58 @@
+// Package rating computes Elo ratings. Players start at Initial and move
+// faster while they are provisional, so a strong newcomer reaches their
+// level in a handful of games instead of hundreds.
+package rating
+
+import "math"
+
+const (
+	Initial = 1500
+
+	// ProvisionalGames is how many rated games a player needs before their
+	// rating is considered established.
+	ProvisionalGames = 20
+
+	KProvisional = 40 // K-factor while provisional
+	K            = 20 // K-factor once established
+)
+
+// Pools keep separate ratings, so beating bots does not inflate a rating
+// earned against people.
+const (
+	PoolHuman = "human"
+	PoolBot   = "bot"
+)
+
+// Provisional reports whether a rating after games rated games is still
+// settling.
+func Provisional(games int) bool {
+	return games < ProvisionalGames
+}
+
+// Expected returns the score a player rated r is expected to make against
+// one rated opp, between 0 and 1.
+func Expected(r, opp int) float64 {
+	return 1 / (1 + math.Pow(10, float64(opp-r)/400))
+}
+
+// Update returns the new rating of a player rated r, with games rated games
+// behind them, who scored score (1 win, 0.5 draw, 0 loss) against opp.
+func Update(r, opp, games int, score float64) int {
+	k := float64(K)
+	if Provisional(games) {
+		k = KProvisional
+	}
+	return r + int(math.Round(k*(score-Expected(r, opp))))
+}
+
+// Score returns what player (1 or 2) scored in a game won by winner, 0 for
+// a draw.
+func Score(player, winner int) float64 {
+	switch winner {
+	case 0:
+		return 0.5
+	case player:
+		return 1
+	}
+	return 0
+}
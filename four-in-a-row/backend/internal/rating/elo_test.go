@@ -0,0 +1,83 @@
+package rating
+
+import (
+	"math"
+	"testing"
+)
+
+func TestExpected(t *testing.T) {
+	tests := []struct {
+		r, opp int
+		want   float64
+	}{
+		{1500, 1500, 0.5},
+		{1500, 1900, 1.0 / 11},
+		{1900, 1500, 10.0 / 11},
+		{1700, 1500, 1 / (1 + math.Pow(10, -0.5))},
+	}
+	for _, tt := range tests {
+		if got := Expected(tt.r, tt.opp); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("Expected(%d, %d) = %v, want %v", tt.r, tt.opp, got, tt.want)
+		}
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	tests := []struct {
+		name          string
+		r, opp, games int
+		score         float64
+		want          int
+	}{
+		{"provisional win", 1500, 1500, 0, 1, 1520},
+		{"last provisional loss", 1500, 1500, ProvisionalGames - 1, 0, 1480},
+		{"established win", 1500, 1500, ProvisionalGames, 1, 1510},
+		{"equal draw", 1500, 1500, 50, 0.5, 1500},
+		{"draw with a weaker player", 1700, 1500, 25, 0.5, 1695},
+		{"upset while provisional", 1500, 1900, 5, 1, 1536},
+		{"expected win", 1900, 1500, 30, 1, 1902},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Update(tt.r, tt.opp, tt.games, tt.score); got != tt.want {
+				t.Errorf("Update(%d, %d, %d, %v) = %d, want %d", tt.r, tt.opp, tt.games, tt.score, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProvisional(t *testing.T) {
+	tests := []struct {
+		games int
+		want  bool
+	}{
+		{0, true},
+		{ProvisionalGames - 1, true},
+		{ProvisionalGames, false},
+		{100, false},
+	}
+	for _, tt := range tests {
+		if got := Provisional(tt.games); got != tt.want {
+			t.Errorf("Provisional(%d) = %v, want %v", tt.games, got, tt.want)
+		}
+	}
+}
+
+func TestScore(t *testing.T) {
+	tests := []struct {
+		player, winner int
+		want           float64
+	}{
+		{1, 1, 1},
+		{2, 1, 0},
+		{1, 2, 0},
+		{2, 2, 1},
+		{1, 0, 0.5},
+		{2, 0, 0.5},
+	}
+	for _, tt := range tests {
+		if got := Score(tt.player, tt.winner); got != tt.want {
+			t.Errorf("Score(%d, %d) = %v, want %v", tt.player, tt.winner, got, tt.want)
+		}
+	}
+}
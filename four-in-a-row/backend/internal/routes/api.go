@@ -1,27 +1,313 @@
 package routes
 
 import (
+	"errors"
+	"io"
+	"player/backend/internal/bot"
+	"player/backend/internal/game"
+	"player/backend/internal/rating"
 	"player/backend/internal/server"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-func RegisterRoutes(r *gin.Engine, pg *server.PGStore) {
+func RegisterRoutes(r *gin.Engine, pg *server.PGStore, ws *server.WSHandler) {
+	// Ranks by rating; pool=bot for ratings from games against the bot.
 	r.GET("/leaderboard", func(c *gin.Context) {
-		leaders, err := pg.GetLeaderboard()
+		pool, ok := ratingPool(c)
+		if !ok {
+			return
+		}
+		leaders, err := pg.GetLeaderboard(pool)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, leaders)
+	})
+	// Profile, statistics and recent games of a player
+	r.GET("/players/:username", func(c *gin.Context) {
+		p, err := pg.GetProfile(c.Param("username"))
+		if errors.Is(err, server.ErrUserNotFound) {
+			c.JSON(404, gin.H{"error": "player not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, p)
+	})
+	r.GET("/players/:username/ratings", func(c *gin.Context) {
+		pool, ok := ratingPool(c)
+		if !ok {
+			return
+		}
+		history, err := pg.RatingHistory(c.Param("username"), pool, 100)
 		if err != nil {
 			c.JSON(500, gin.H{"error": err.Error()})
 			return
 		}
-		// Exclude bot from leaderboard
-		arr := []interface{}{}
-		for _, l := range leaders {
-			if l.Username == "bot" {
-				continue
-			}
-			arr = append(arr, l)
+		c.JSON(200, history)
+	})
+	// A private room waiting for its second player, for showing an invite.
+	r.GET("/rooms/:code", func(c *gin.Context) {
+		room, ok := ws.Rooms().Get(c.Param("code"))
+		if !ok {
+			c.JSON(404, gin.H{"error": server.ErrRoomNotFound.Error()})
+			return
+		}
+		c.JSON(200, room)
+	})
+	// Games in progress, for spectators to pick one to watch.
+	r.GET("/games/live", func(c *gin.Context) {
+		c.JSON(200, ws.LiveGames())
+	})
+	r.GET("/games/:id", func(c *gin.Context) {
+		rec, ok := loadGame(c, pg)
+		if !ok {
+			return
+		}
+		c.JSON(200, rec)
+	})
+	r.GET("/games/:id/replay", func(c *gin.Context) {
+		rec, ok := loadGame(c, pg)
+		if !ok {
+			return
+		}
+		steps, err := game.Replay(rec.Rules, rec.Moves, rec.Winner, rec.Reason)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "stored moves do not replay: " + err.Error()})
+			return
+		}
+		c.JSON(200, gin.H{
+			"id":      rec.ID,
+			"player1": rec.Player1,
+			"player2": rec.Player2,
+			"winner":  rec.Winner,
+			"reason":  rec.Reason,
+			"rules":   rec.Rules,
+			"steps":   steps,
+		})
+	})
+	r.GET("/games/:id/export", func(c *gin.Context) {
+		rec, ok := loadGame(c, pg)
+		if !ok {
+			return
+		}
+		cols := make([]int, len(rec.Moves))
+		for i, m := range rec.Moves {
+			cols[i] = m.Column
+		}
+		text := game.Record{
+			Player1: rec.Player1,
+			Player2: rec.Player2,
+			Result:  game.ResultOf(true, rec.Winner),
+			Date:    rec.CreatedAt,
+			Rules:   rec.Rules,
+			Columns: cols,
+			Reason:  rec.Reason,
+		}.String()
+		c.Header("Content-Disposition", `attachment; filename="`+rec.ID+`.c4"`)
+		c.String(200, text)
+	})
+	// Import one of your own finished games in text notation; every move is
+	// replayed before it is stored, and it is stored as imported and unranked.
+	r.POST("/games/import", func(c *gin.Context) {
+		username, err := ws.Accounts().Authenticate(server.BearerToken(c))
+		if err != nil {
+			c.JSON(401, gin.H{"error": err.Error()})
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(c.Request.Body, 64<<10))
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		rec, err := game.ParseRecord(string(body))
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		if rec.Player1 != username && rec.Player2 != username {
+			c.JSON(403, gin.H{"error": server.ErrNotInGame.Error()})
+			return
+		}
+		g, err := rec.Game()
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		if !g.Finished {
+			c.JSON(400, gin.H{"error": "only finished games can be imported"})
+			return
+		}
+		if err := pg.ImportGame(g, rec.Player1, rec.Player2, rec.Date); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(201, gin.H{"id": g.ID, "result": game.ResultOf(g.Finished, g.Winner), "moves": len(g.Moves)})
+	})
+	// Matchmaking queue sizes, waits and how players left them
+	r.GET("/matchmaking/stats", func(c *gin.Context) {
+		c.JSON(200, ws.Matchmaker().Stats())
+	})
+	// Transposition table statistics for each bot level and the solver
+	r.GET("/bot/stats", func(c *gin.Context) {
+		c.JSON(200, bot.AllTableStats())
+	})
+	// Suggest a move in a live game; counts against the hints of the player
+	// the session or resume token belongs to.
+	r.POST("/games/:id/hint", func(c *gin.Context) {
+		username, err := ws.PlayerFor(c.Param("id"), server.BearerToken(c))
+		if err != nil {
+			c.JSON(401, gin.H{"error": err.Error()})
+			return
+		}
+		res, err := ws.Hint(c.Param("id"), username)
+		switch {
+		case errors.Is(err, server.ErrNoGame):
+			c.JSON(404, gin.H{"error": err.Error()})
+		case errors.Is(err, server.ErrNotInGame):
+			c.JSON(403, gin.H{"error": err.Error()})
+		case errors.Is(err, game.ErrHintLimit):
+			c.JSON(429, gin.H{"error": err.Error()})
+		case errors.Is(err, game.ErrNotYourTurn), errors.Is(err, game.ErrGameOver):
+			c.JSON(409, gin.H{"error": err.Error()})
+		case err != nil:
+			c.JSON(500, gin.H{"error": err.Error()})
+		default:
+			c.JSON(200, res)
+		}
+	})
+	// Solve a position given as a move sequence or a board.
+	r.POST("/analysis", func(c *gin.Context) {
+		var req analysisRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		g, err := req.game()
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		a, err := bot.NewSolver(analysisBudget).Analyze(g)
+		if errors.Is(err, bot.ErrGameOver) || errors.Is(err, game.ErrBoardTooLarge) {
+			c.JSON(422, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, a)
+	})
+	// Accounts: both answer with a session token for /ws?token=...
+	r.POST("/auth/register", func(c *gin.Context) {
+		var req credentials
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		tok, err := ws.Accounts().Register(req.Username, req.Password)
+		switch {
+		case errors.Is(err, server.ErrBadUsername), errors.Is(err, server.ErrWeakPassword):
+			c.JSON(400, gin.H{"error": err.Error()})
+		case errors.Is(err, server.ErrUsernameTaken):
+			c.JSON(409, gin.H{"error": err.Error()})
+		case err != nil:
+			c.JSON(500, gin.H{"error": err.Error()})
+		default:
+			c.JSON(201, tok)
+		}
+	})
+	r.POST("/auth/login", func(c *gin.Context) {
+		var req credentials
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		tok, err := ws.Accounts().Login(req.Username, req.Password)
+		switch {
+		case errors.Is(err, server.ErrBadCredentials):
+			c.JSON(401, gin.H{"error": err.Error()})
+		case err != nil:
+			c.JSON(500, gin.H{"error": err.Error()})
+		default:
+			c.JSON(200, tok)
 		}
-		c.JSON(200, arr)
 	})
 	// ...other routes
 }
+
+type credentials struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// analysisBudget bounds how long one /analysis request may search. Early
+// positions on the full board usually take longer than this, in which case
+// the unsolved columns come back with a heuristic eval instead.
+const analysisBudget = 5 * time.Second
+
+// analysisRequest names a position either by its moves, in notation form
+// ("4453"), or by its board. Rules default to the classic board.
+type analysisRequest struct {
+	Rules *game.Rules `json:"rules"`
+	Moves *string     `json:"moves"`
+	Board [][]int     `json:"board"`
+}
+
+func (req analysisRequest) game() (*game.Game, error) {
+	rules := game.DefaultRules()
+	if req.Rules != nil {
+		rules = *req.Rules
+	}
+	if err := rules.Validate(); err != nil {
+		return nil, err
+	}
+	switch {
+	case req.Moves != nil && req.Board != nil:
+		return nil, errors.New("send either moves or board, not both")
+	case req.Board != nil:
+		return game.FromBoard(rules, req.Board)
+	case req.Moves != nil:
+		cols, err := game.ParseMoves(rules, *req.Moves)
+		if err != nil {
+			return nil, err
+		}
+		rec := game.Record{Result: game.ResultOngoing, Rules: rules, Columns: cols}
+		return rec.Game()
+	default:
+		return game.NewGameWithRules(rules)
+	}
+}
+
+// ratingPool reads the pool query parameter, human unless it says bot,
+// writing the error response itself when it is neither.
+func ratingPool(c *gin.Context) (string, bool) {
+	switch pool := c.DefaultQuery("pool", rating.PoolHuman); pool {
+	case rating.PoolHuman, rating.PoolBot:
+		return pool, true
+	default:
+		c.JSON(400, gin.H{"error": "pool must be human or bot"})
+		return "", false
+	}
+}
+
+// loadGame fetches the game named in the :id path parameter, writing the
+// error response itself when it cannot.
+func loadGame(c *gin.Context, pg *server.PGStore) (*server.GameRecord, bool) {
+	rec, err := pg.GetGame(c.Param("id"))
+	if errors.Is(err, server.ErrGameNotFound) {
+		c.JSON(404, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return rec, true
+}
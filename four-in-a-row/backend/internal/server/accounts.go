@@ -0,0 +1,197 @@
+package server
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrBadUsername = errors.New("username must be 3 to 32 letters, digits, '-' or '_'")
+var ErrWeakPassword = errors.New("password must be at least 8 characters")
+var ErrUsernameTaken = errors.New("username is taken")
+var ErrBadCredentials = errors.New("wrong username or password")
+var ErrRegistered = errors.New("username belongs to an account, log in to use it")
+var ErrReservedName = errors.New("username is reserved")
+
+// SessionTTL is how long a login lasts.
+const SessionTTL = 7 * 24 * time.Hour
+
+// sessionKind tells session tokens apart from resume tokens.
+const sessionKind = "session"
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{3,32}$`)
+
+// Accounts registers and logs in players. A login gives out a session token
+// signed with the same key as resume tokens, so it needs no lookup to check.
+type Accounts struct {
+	users  userStore // nil when there is no database
+	signer *Signer
+}
+
+// userStore is where accounts and their password hashes are kept.
+type userStore interface {
+	CreateUser(username, hash string) error
+	PasswordHash(username string) (string, error)
+}
+
+func NewAccounts(pg *PGStore, signer *Signer) *Accounts {
+	a := &Accounts{signer: signer}
+	if pg != nil {
+		a.users = pg
+	}
+	return a
+}
+
+// AuthToken is what a player gets back from registering or logging in.
+type AuthToken struct {
+	Username  string    `json:"username"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Register creates an account and logs it in.
+func (a *Accounts) Register(username, password string) (AuthToken, error) {
+	if !usernamePattern.MatchString(username) || username == "bot" {
+		return AuthToken{}, ErrBadUsername
+	}
+	if len(password) < 8 {
+		return AuthToken{}, ErrWeakPassword
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return AuthToken{}, err
+	}
+	if err := a.users.CreateUser(username, string(hash)); err != nil {
+		return AuthToken{}, err
+	}
+	return a.session(username), nil
+}
+
+// Login checks a password and starts a new session.
+func (a *Accounts) Login(username, password string) (AuthToken, error) {
+	hash, err := a.users.PasswordHash(username)
+	if errors.Is(err, ErrUserNotFound) {
+		// compare anyway so a missing account takes as long as a wrong password
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return AuthToken{}, ErrBadCredentials
+	}
+	if err != nil {
+		return AuthToken{}, err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return AuthToken{}, ErrBadCredentials
+	}
+	return a.session(username), nil
+}
+
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a password"), bcrypt.DefaultCost)
+
+func (a *Accounts) session(username string) AuthToken {
+	exp := time.Now().Add(SessionTTL).Truncate(time.Second)
+	return AuthToken{
+		Username:  username,
+		Token:     a.signer.Sign(sessionKind, username, strconv.FormatInt(exp.Unix(), 10)),
+		ExpiresAt: exp.UTC(),
+	}
+}
+
+// Authenticate returns the account a session token was issued to, if it
+// has not expired.
+func (a *Accounts) Authenticate(token string) (string, error) {
+	f, err := a.signer.Verify(token, 3)
+	if err != nil || f[0] != sessionKind {
+		return "", ErrBadToken
+	}
+	exp, err := strconv.ParseInt(f[2], 10, 64)
+	if err != nil || time.Now().Unix() >= exp {
+		return "", ErrBadToken
+	}
+	return f[1], nil
+}
+
+// CheckGuest refuses guest names that an account could not have or that
+// belong to one, so a guest cannot pass for a registered player.
+func (a *Accounts) CheckGuest(username string) error {
+	if !usernamePattern.MatchString(username) {
+		return ErrBadUsername
+	}
+	if username == "bot" {
+		return ErrReservedName
+	}
+	if a.users == nil {
+		return nil
+	}
+	_, err := a.users.PasswordHash(username)
+	switch {
+	case err == nil:
+		return ErrRegistered
+	case errors.Is(err, ErrUserNotFound):
+		return nil
+	}
+	return err
+}
+
+// BearerToken returns the session token sent with a request, from an
+// "Authorization: Bearer" header or, since browsers cannot set headers on
+// WebSocket requests, the token query parameter.
+func BearerToken(c *gin.Context) string {
+	if t, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok {
+		return t
+	}
+	return c.Query("token")
+}
+
+var ErrUserNotFound = errors.New("user not found")
+
+// CreateUser adds an account with a bcrypt password hash.
+func (s *PGStore) CreateUser(username, hash string) error {
+	res, err := s.db.Exec(`INSERT INTO users (username, password_hash) VALUES ($1,$2) ON CONFLICT (username) DO NOTHING`, username, hash)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrUsernameTaken
+	}
+	return nil
+}
+
+// PasswordHash returns the bcrypt hash stored for an account.
+func (s *PGStore) PasswordHash(username string) (string, error) {
+	var hash sql.NullString
+	err := s.db.QueryRow(`SELECT password_hash FROM users WHERE username=$1`, username).Scan(&hash)
+	if err == sql.ErrNoRows || (err == nil && !hash.Valid) {
+		return "", ErrUserNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("look up user %s: %w", username, err)
+	}
+	return hash.String, nil
+}
+
+// AddResult counts a ranked game in the players' account records. Players
+// without an account are left alone.
+func (s *PGStore) AddResult(p1, p2 string, winner int) error {
+	return addResult(s.db, p1, p2, winner)
+}
+
+func addResult(db execer, p1, p2 string, winner int) error {
+	var won, lost string
+	switch winner {
+	case 1:
+		won, lost = p1, p2
+	case 2:
+		won, lost = p2, p1
+	}
+	_, err := db.Exec(`UPDATE users SET games_played = games_played + 1,
+	wins = wins + CASE WHEN username = $3 THEN 1 ELSE 0 END,
+	losses = losses + CASE WHEN username = $4 THEN 1 ELSE 0 END
+WHERE username IN ($1, $2)`, p1, p2, won, lost)
+	return err
+}
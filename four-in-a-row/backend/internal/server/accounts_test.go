@@ -0,0 +1,93 @@
+package server
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// fakeUsers keeps accounts in memory in place of the users table.
+type fakeUsers map[string]string
+
+func (f fakeUsers) CreateUser(username, hash string) error {
+	if _, ok := f[username]; ok {
+		return ErrUsernameTaken
+	}
+	f[username] = hash
+	return nil
+}
+
+func (f fakeUsers) PasswordHash(username string) (string, error) {
+	hash, ok := f[username]
+	if !ok {
+		return "", ErrUserNotFound
+	}
+	return hash, nil
+}
+
+func newTestAccounts(t *testing.T) *Accounts {
+	t.Helper()
+	a := &Accounts{users: fakeUsers{}, signer: NewSigner([]byte("secret"))}
+	if _, err := a.Register("alice", "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestLogin(t *testing.T) {
+	a := newTestAccounts(t)
+	tests := []struct {
+		name     string
+		username string
+		password string
+		want     error
+	}{
+		{"good", "alice", "correct horse", nil},
+		{"wrong password", "alice", "battery staple", ErrBadCredentials},
+		{"unknown user", "bob", "correct horse", ErrBadCredentials},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tok, err := a.Login(tt.username, tt.password)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Login() error = %v, want %v", err, tt.want)
+			}
+			if err != nil {
+				return
+			}
+			if name, err := a.Authenticate(tok.Token); err != nil || name != tt.username {
+				t.Errorf("Authenticate() = %q, %v, want %q", name, err, tt.username)
+			}
+		})
+	}
+}
+
+func TestRegisterTaken(t *testing.T) {
+	a := newTestAccounts(t)
+	if _, err := a.Register("alice", "another password"); !errors.Is(err, ErrUsernameTaken) {
+		t.Errorf("Register() of a taken name error = %v, want %v", err, ErrUsernameTaken)
+	}
+}
+
+func TestCheckGuest(t *testing.T) {
+	a := newTestAccounts(t)
+	tests := []struct {
+		name     string
+		username string
+		want     error
+	}{
+		{"free name", "bob", nil},
+		{"registered", "alice", ErrRegistered},
+		{"the bot", "bot", ErrReservedName},
+		{"too short", "al", ErrBadUsername},
+		{"too long", strings.Repeat("a", 33), ErrBadUsername},
+		{"space", "al ice", ErrBadUsername},
+		{"punctuation", "alice!", ErrBadUsername},
+		{"empty", "", ErrBadUsername},
+	}
+	for _, tt := range tests {
+		if err := a.CheckGuest(tt.username); !errors.Is(err, tt.want) {
+			t.Errorf("%s: CheckGuest(%q) error = %v, want %v", tt.name, tt.username, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"time"
+
+	"player/backend/internal/game"
+)
+
+// botMargin is kept back from the bot's clock for playing and sending its
+// move once it has chosen one.
+const botMargin = 100 * time.Millisecond
+
+// botBudget is how long the bot may think with left on its clock: a
+// twentieth of it plus most of the increment, or half of a per-move
+// allowance, and never so long that it runs out of time.
+func botBudget(tc game.TimeControl, left time.Duration) time.Duration {
+	budget := left/20 + tc.Increment*3/4
+	if tc.PerMove > 0 {
+		budget = left / 2
+	}
+	if most := left - botMargin; budget > most {
+		budget = most
+	}
+	return max(budget, time.Millisecond)
+}
+
+// flagGrace is added to a clock's remaining time before it is checked, so
+// the timer does not fire a hair before the time is actually up.
+const flagGrace = 10 * time.Millisecond
+
+// watchClock arms a timer for the moment the player to move runs out of
+// time. If they have not moved by then the game is lost on time, saved and
+// broadcast. Any timer armed for an earlier turn is replaced, and the
+// players are sent the clock as it now stands.
+func (h *WSHandler) watchClock(gid string, g *game.Game, players []string) {
+	h.stopClock(gid)
+	lock := h.mgr.GameLock(gid)
+	lock.Lock()
+	if g.Clock == nil || g.Finished || g.Clock.Running == 0 {
+		lock.Unlock()
+		return
+	}
+	left := g.Clock.Left(g.Clock.Running, time.Now())
+	clock := g.Clone().Clock
+	lock.Unlock()
+	t := time.AfterFunc(left+flagGrace, func() {
+		lock.Lock()
+		flagged := g.CheckFlag(time.Now())
+		snap := g.Clone()
+		lock.Unlock()
+		if !flagged {
+			return
+		}
+		h.mgr.Add(g, players...)
+		h.broadcastGame(gid, snap)
+		h.finish(gid, snap, players)
+	})
+	h.mu.Lock()
+	h.flags[gid] = t
+	h.mu.Unlock()
+	h.broadcast(gid, MsgClock, clock)
+}
+
+func (h *WSHandler) stopClock(gid string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if t := h.flags[gid]; t != nil {
+		t.Stop()
+		delete(h.flags, gid)
+	}
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"errors"
+
+	"player/backend/internal/bot"
+	"player/backend/internal/game"
+)
+
+var ErrNoGame = errors.New("no active game")
+var ErrNotInGame = errors.New("not a player in this game")
+
+// HintResult is what a player who asked for a hint gets back.
+type HintResult struct {
+	bot.Hint
+	HintsLeft int `json:"hints_left"`
+}
+
+// Hint suggests a move for username in the live game gid and counts it
+// against their allowance. The game is broadcast afterwards so the opponent
+// can see a hint was used; such games are saved as unranked.
+func (h *WSHandler) Hint(gid, username string) (HintResult, error) {
+	g, ok := h.mgr.Get(gid)
+	if !ok {
+		return HintResult{}, ErrNoGame
+	}
+	pnum := playerNumber(h.mgr.GetPlayers(gid), username)
+	if pnum == 0 {
+		return HintResult{}, ErrNotInGame
+	}
+	lock := h.mgr.GameLock(gid)
+	lock.Lock()
+	var err error
+	switch {
+	case g.Finished:
+		err = game.ErrGameOver
+	case g.Turn != pnum:
+		err = game.ErrNotYourTurn
+	default:
+		err = g.UseHint(pnum)
+	}
+	snap := g.Clone()
+	lock.Unlock()
+	if err != nil {
+		return HintResult{}, err
+	}
+	pos := snap.CloneUntimed()
+	hint, err := bot.Suggest(pos)
+	if err != nil {
+		return HintResult{}, err
+	}
+	h.broadcastGame(gid, snap)
+	return HintResult{Hint: hint, HintsLeft: pos.HintsLeft(pnum)}, nil
+}
+
+// playerNumber returns 1 or 2 for a player of the game, 0 for anyone else.
+func playerNumber(players []string, username string) int {
+	for i, p := range players {
+		if p == username {
+			return i + 1
+		}
+	}
+	return 0
+}
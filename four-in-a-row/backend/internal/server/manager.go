@@ -1,6 +1,7 @@
 package server
 
 import (
+	"player/backend/internal/bot"
 	"player/backend/internal/game"
 	"sync"
 )
@@ -8,8 +9,11 @@ import (
 type Manager struct {
 	mu           sync.Mutex
 	games        map[string]*game.Game
-	playerToGame map[string]string   // username -> gameID
-	gamePlayers  map[string][]string // gameID -> usernames
+	playerToGame map[string]string      // username -> gameID
+	gamePlayers  map[string][]string    // gameID -> usernames
+	bots         map[string]bot.Engine  // gameID -> engine playing as "bot"
+	locks        map[string]*sync.Mutex // gameID -> lock held while the game is changed
+	rooms        map[string]string      // gameID -> code of the private room it was started in
 }
 
 func NewManager() *Manager {
@@ -17,6 +21,9 @@ func NewManager() *Manager {
 		games:        make(map[string]*game.Game),
 		playerToGame: make(map[string]string),
 		gamePlayers:  make(map[string][]string),
+		bots:         make(map[string]bot.Engine),
+		locks:        make(map[string]*sync.Mutex),
+		rooms:        make(map[string]string),
 	}
 }
 
@@ -39,6 +46,31 @@ func (m *Manager) Get(id string) (*game.Game, bool) {
 	return g, ok
 }
 
+// IDs returns the ids of every game being tracked.
+func (m *Manager) IDs() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ids := make([]string, 0, len(m.games))
+	for id := range m.games {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// GameLock returns the lock that moves, offers, hints and the timers of a
+// game hold while they change it, so a player, the bot and a running clock
+// never change it at once.
+func (m *Manager) GameLock(gameID string) *sync.Mutex {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l := m.locks[gameID]
+	if l == nil {
+		l = &sync.Mutex{}
+		m.locks[gameID] = l
+	}
+	return l
+}
+
 // GetGameByPlayer returns the game and gameID for a player if active
 func (m *Manager) GetGameByPlayer(username string) (*game.Game, string, bool) {
 	m.mu.Lock()
@@ -58,11 +90,47 @@ func (m *Manager) GetPlayers(gameID string) []string {
 	return m.gamePlayers[gameID]
 }
 
+// SetBot records which engine plays for the bot in a game.
+func (m *Manager) SetBot(gameID string, e bot.Engine) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.bots[gameID] = e
+}
+
+// Bot returns the engine playing in a game, or the default level if none was set.
+func (m *Manager) Bot(gameID string) bot.Engine {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e, ok := m.bots[gameID]; ok {
+		return e
+	}
+	return bot.ForLevel(bot.DefaultLevel)
+}
+
+// SetRoom marks a game as private to the room with code.
+func (m *Manager) SetRoom(gameID, code string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rooms[gameID] = code
+}
+
+// Room returns the code of the private room a game was started in, if it
+// was.
+func (m *Manager) Room(gameID string) (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	code, ok := m.rooms[gameID]
+	return code, ok
+}
+
 func (m *Manager) Remove(id string) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	delete(m.games, id)
 	delete(m.gamePlayers, id)
+	delete(m.bots, id)
+	delete(m.locks, id)
+	delete(m.rooms, id)
 	// remove playerToGame entries
 	for p, gid := range m.playerToGame {
 		if gid == id {
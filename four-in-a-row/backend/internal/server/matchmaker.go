@@ -1,51 +1,469 @@
 package server
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"player/backend/internal/bot"
 	"player/backend/internal/game"
+	"player/backend/internal/rating"
+	"player/backend/internal/services"
+	"sort"
 	"sync"
 	"time"
 )
 
+var ErrMatchmakingStopped = errors.New("matchmaking has stopped")
+var ErrQueuedElsewhere = errors.New("waiting for a game on another connection")
+var ErrNameWaiting = errors.New("someone with that name is already waiting for a game")
+
+// MatchWait is how long a player waits for an opponent before playing the bot.
+const MatchWait = 10 * time.Second
+
+// Players are paired when their ratings are within both of their search
+// windows, which start at InitialWindow and widen by WindowGrowth every
+// second they wait, up to MaxWindow.
+const (
+	InitialWindow = 100
+	WindowGrowth  = 50
+	MaxWindow     = 1000
+)
+
+// matchTick is how often the queues are looked at again as windows widen.
+const matchTick = 500 * time.Millisecond
+
+// waitSmoothing is the weight of the latest wait in a queue's running
+// average.
+const waitSmoothing = 0.2
+
+// Matchmaker pairs the players in its queues as they join, and again every
+// matchTick as their search windows widen, while Run runs. Nobody blocks
+// on it: a player joins, gets a Ticket and hears about their match on it.
 type Matchmaker struct {
 	mu      sync.Mutex
-	waiting map[string]*Session
+	queues  map[QueueKey]*queue
+	wake    chan struct{}
+	onMatch func(Match) // sets up each game before its players hear of it
+	stopped bool
 }
 
 type Session struct {
 	Username string
+	Rules    game.Rules
+	Time     game.TimeControl
+	Bot      string // bot level or personality to play if nobody else turns up
+	Guest    bool   // not logged in; their games are casual
+	Rating   int    // human pool rating, rating.Initial for guests and new players
 	JoinedAt time.Time
 }
 
+// Match is a game the matchmaker or a rematch started.
+type Match struct {
+	Game *game.Game
+	// Players are in the order they play. The matchmaker puts the bot
+	// second, but a rematch swaps colours, so look it up with playerNumber.
+	Players []string
+	Engine  bot.Engine // the bot's engine in a game against it, else nil
+}
+
+// You returns which player username is in the match, 1 or 2.
+func (m Match) You(username string) int {
+	if m.Players[1] == username {
+		return 2
+	}
+	return 1
+}
+
+// Opponent returns who username plays against.
+func (m Match) Opponent(username string) string {
+	return m.Players[2-m.You(username)]
+}
+
+// Ticket is a player's place in a queue. C gets their match, or is closed
+// without one, with Err saying why, if they join again elsewhere or
+// matchmaking stops first.
+type Ticket struct {
+	Session
+	C   <-chan Match
+	c   chan Match
+	key QueueKey
+	err error
+}
+
+// Err returns why C was closed without a match.
+func (t *Ticket) Err() error {
+	return t.err
+}
+
+// QueueKey picks a queue: players only meet others wanting the same rules
+// and time control.
+type QueueKey struct {
+	Rules game.Rules
+	Time  game.TimeControl
+}
+
+// queue holds the players waiting for one rule set and time control, oldest
+// first, and counts how they left it.
+type queue struct {
+	waiting   []*Ticket
+	matched   int
+	botGames  int
+	cancelled int
+	served    int           // players who left the queue with a game
+	avgWait   time.Duration // running average of how long they waited
+}
+
 func NewMatchmaker() *Matchmaker {
-	return &Matchmaker{waiting: make(map[string]*Session)}
+	return &Matchmaker{queues: make(map[QueueKey]*queue), wake: make(chan struct{}, 1)}
+}
+
+func (m *Matchmaker) queue(key QueueKey) *queue {
+	q, ok := m.queues[key]
+	if !ok {
+		q = &queue{}
+		m.queues[key] = q
+	}
+	return q
+}
+
+// window is how far from their own rating a player who has waited this
+// long will accept an opponent.
+func window(waited time.Duration) int {
+	w := InitialWindow + int(waited.Seconds()*WindowGrowth)
+	if w > MaxWindow {
+		return MaxWindow
+	}
+	return w
+}
+
+func fits(a, b *Ticket, now time.Time) bool {
+	if a.Username == b.Username {
+		return false
+	}
+	diff := a.Rating - b.Rating
+	if diff < 0 {
+		diff = -diff
+	}
+	return diff <= window(now.Sub(a.JoinedAt)) && diff <= window(now.Sub(b.JoinedAt))
+}
+
+func (q *queue) index(t *Ticket) int {
+	for i, w := range q.waiting {
+		if w == t {
+			return i
+		}
+	}
+	return -1
+}
+
+// removeAt takes a player out of the queue with a game.
+func (q *queue) removeAt(i int, now time.Time) *Ticket {
+	t := q.waiting[i]
+	q.left(now.Sub(t.JoinedAt))
+	q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+	return t
+}
+
+// left counts a player's wait in the queue's average.
+func (q *queue) left(waited time.Duration) {
+	if q.served == 0 {
+		q.avgWait = waited
+	} else {
+		q.avgWait += time.Duration(waitSmoothing * float64(waited-q.avgWait))
+	}
+	q.served++
 }
 
-// AddWaiting adds a user to waiting pool and returns after timeout a match decision.
-func (m *Matchmaker) AddWaiting(username string, wait time.Duration) (*game.Game, bool, string) {
+// next finds the pair to match first: the oldest waiting player who fits
+// anyone, with the oldest of those who fit them, so nobody is passed over
+// for someone who came later.
+func (q *queue) next(now time.Time) (int, int, bool) {
+	for i, a := range q.waiting {
+		for j := i + 1; j < len(q.waiting); j++ {
+			if fits(a, q.waiting[j], now) {
+				return i, j, true
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// Join puts s at the back of the queue for its rules and time control. A
+// logged in player who is already waiting, from another tab say, loses
+// their old place in whichever queue it was, and that ticket is closed.
+// Nothing proves who a guest is, so a name that is waiting already is
+// refused with ErrNameWaiting rather than let a guest push anyone out.
+func (m *Matchmaker) Join(s Session) (*Ticket, error) {
+	c := make(chan Match, 1)
+	t := &Ticket{Session: s, C: c, c: c, key: QueueKey{Rules: s.Rules, Time: s.Time}}
+	t.JoinedAt = time.Now()
 	m.mu.Lock()
-	// if someone else waiting, match
-	for other := range m.waiting {
-		if other != username {
-			delete(m.waiting, other)
-			m.mu.Unlock()
-			g := game.NewGame()
-			return g, false, other
+	if m.stopped {
+		m.mu.Unlock()
+		return nil, ErrMatchmakingStopped
+	}
+	for _, q := range m.queues {
+		for i, w := range q.waiting {
+			if w.Username != s.Username {
+				continue
+			}
+			if w.Guest || s.Guest {
+				m.mu.Unlock()
+				return nil, ErrNameWaiting
+			}
+			q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+			q.cancelled++
+			w.err = ErrQueuedElsewhere
+			close(w.c)
+			break
 		}
 	}
-	// otherwise add self and wait
-	m.waiting[username] = &Session{Username: username, JoinedAt: time.Now()}
+	q := m.queue(t.key)
+	q.waiting = append(q.waiting, t)
 	m.mu.Unlock()
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+	return t, nil
+}
 
-	timer := time.NewTimer(wait)
-	<-timer.C
+// Cancel takes a ticket out of its queue and reports whether it was still
+// waiting; if not, its match is already on the way.
+func (m *Matchmaker) Cancel(t *Ticket) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	q := m.queues[t.key]
+	i := q.index(t)
+	if i < 0 {
+		return false
+	}
+	q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+	q.cancelled++
+	return true
+}
 
+// Run matches players until ctx is done, then closes every waiting ticket
+// and refuses new ones.
+func (m *Matchmaker) Run(ctx context.Context) {
+	tick := time.NewTicker(matchTick)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			m.stop()
+			return
+		case <-tick.C:
+		case <-m.wake:
+		}
+		m.match(time.Now())
+	}
+}
+
+func (m *Matchmaker) stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stopped = true
+	for _, q := range m.queues {
+		for _, t := range q.waiting {
+			t.err = ErrMatchmakingStopped
+			close(t.c)
+		}
+		q.waiting = nil
+	}
+}
+
+// match pairs everyone it can, oldest first, and gives those who have
+// waited MatchWait a game against the bot. Each game is set up with
+// onMatch before its players are told, outside the lock. A game with a
+// guest in it is casual.
+func (m *Matchmaker) match(now time.Time) {
+	type found struct {
+		match   Match
+		tickets []*Ticket
+	}
+	var games []found
+	m.mu.Lock()
+	for key, q := range m.queues {
+		for {
+			i, j, ok := q.next(now)
+			if !ok {
+				break
+			}
+			b := q.removeAt(j, now)
+			a := q.removeAt(i, now)
+			q.matched += 2
+			g, _ := game.NewGameWithRules(key.Rules)
+			g.SetTimeControl(key.Time)
+			g.Casual = a.Guest || b.Guest
+			games = append(games, found{Match{Game: g, Players: []string{a.Username, b.Username}}, []*Ticket{a, b}})
+		}
+		for len(q.waiting) > 0 && now.Sub(q.waiting[0].JoinedAt) >= MatchWait {
+			t := q.removeAt(0, now)
+			q.botGames++
+			g, _ := game.NewGameWithRules(key.Rules)
+			g.SetTimeControl(key.Time)
+			g.Casual = t.Guest
+			games = append(games, found{Match{Game: g, Players: []string{t.Username, "bot"}, Engine: bot.ForName(t.Bot)}, []*Ticket{t}})
+		}
+	}
+	onMatch := m.onMatch
+	m.mu.Unlock()
+
+	for _, f := range games {
+		if onMatch != nil {
+			onMatch(f.match)
+		}
+		for _, t := range f.tickets {
+			t.c <- f.match
+		}
+	}
+}
+
+// QueueStatus is what a player joining a queue is told about it.
+type QueueStatus struct {
+	Waiting         int   `json:"waiting"` // players already in the queue
+	Rating          int   `json:"rating"`
+	EstimatedWaitMs int64 `json:"estimated_wait_ms"`
+}
+
+// Status tells s what to expect from its queue: the average wait so far, or
+// the whole wait for the bot before anyone has left it.
+func (m *Matchmaker) Status(s Session) QueueStatus {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	// if still waiting -> remove and return bot game
-	if _, ok := m.waiting[username]; ok {
-		delete(m.waiting, username)
-		g := game.NewGame()
-		return g, true, ""
+	st := QueueStatus{Rating: s.Rating, EstimatedWaitMs: MatchWait.Milliseconds()}
+	q, ok := m.queues[QueueKey{Rules: s.Rules, Time: s.Time}]
+	if !ok {
+		return st
+	}
+	st.Waiting = len(q.waiting)
+	if q.served > 0 && q.avgWait < MatchWait {
+		st.EstimatedWaitMs = q.avgWait.Milliseconds()
+	}
+	return st
+}
+
+// QueueStats describes one queue for monitoring.
+type QueueStats struct {
+	Rules           game.Rules       `json:"rules"`
+	Time            game.TimeControl `json:"time_control"`
+	Waiting         int              `json:"waiting"`
+	LongestWaitMs   int64            `json:"longest_wait_ms"`
+	Matched         int              `json:"matched"`   // players paired with another player
+	BotGames        int              `json:"bot_games"` // players who ended up playing the bot
+	Cancelled       int              `json:"cancelled"` // players who gave up waiting
+	AvgWaitMs       int64            `json:"avg_wait_ms"`
+	EstimatedWaitMs int64            `json:"estimated_wait_ms"`
+}
+
+// Stats returns the state of every queue that has been used, busiest first.
+func (m *Matchmaker) Stats() []QueueStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	res := []QueueStats{}
+	for key, q := range m.queues {
+		st := QueueStats{
+			Rules:           key.Rules,
+			Time:            key.Time,
+			Waiting:         len(q.waiting),
+			Matched:         q.matched,
+			BotGames:        q.botGames,
+			Cancelled:       q.cancelled,
+			AvgWaitMs:       q.avgWait.Milliseconds(),
+			EstimatedWaitMs: MatchWait.Milliseconds(),
+		}
+		if len(q.waiting) > 0 {
+			st.LongestWaitMs = now.Sub(q.waiting[0].JoinedAt).Milliseconds()
+		}
+		if q.served > 0 && q.avgWait < MatchWait {
+			st.EstimatedWaitMs = st.AvgWaitMs
+		}
+		res = append(res, st)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Waiting != res[j].Waiting {
+			return res[i].Waiting > res[j].Waiting
+		}
+		return res[i].Matched+res[i].BotGames > res[j].Matched+res[j].BotGames
+	})
+	return res
+}
+
+// queueFor puts s in the matchmaking queue and waits for its match. It
+// returns false if the player cancelled or left first, or there will be no
+// match; the player is told why.
+func (h *WSHandler) queueFor(cl *client, s Session) (Match, bool) {
+	status := h.mm.Status(s)
+	t, err := h.mm.Join(s)
+	if err != nil {
+		cl.sendError(err)
+		return Match{}, false
+	}
+	cl.send(newMessage(MsgQueued, status), 0)
+	for {
+		select {
+		case match, ok := <-t.C:
+			if !ok {
+				cl.sendError(t.Err())
+			}
+			return match, ok
+		case r := <-cl.in:
+			if r.err == nil && r.cmd.Action != MsgCancel {
+				continue
+			}
+			if errors.Is(r.err, ErrBadMessage) {
+				cl.sendError(r.err)
+				continue
+			}
+			if h.mm.Cancel(t) {
+				return Match{}, false
+			}
+			// matched just as they gave up: the game goes ahead
+			match, ok := <-t.C
+			return match, ok
+		}
+	}
+}
+
+// startMatch sets up a game the matchmaker started before its players
+// hear about it.
+func (h *WSHandler) startMatch(m Match) {
+	gid := m.Game.ID
+	h.mgr.Add(m.Game, m.Players...)
+	if m.Engine != nil {
+		h.mgr.SetBot(gid, m.Engine)
+	}
+	// Emit game started event
+	if h.kafka != nil {
+		payload, _ := json.Marshal(map[string]interface{}{
+			"game_id":   gid,
+			"players":   m.Players,
+			"timestamp": time.Now().UTC(),
+		})
+		h.kafka.Emit(services.EventGameStarted, string(payload))
+	}
+	h.watchClock(gid, m.Game, m.Players)
+}
+
+// Matchmaker returns the matchmaker pairing this handler's players.
+func (h *WSHandler) Matchmaker() *Matchmaker {
+	return h.mm
+}
+
+// queueRating is the rating a player is matched by: their human pool
+// rating if they have an account, the starting rating otherwise.
+func (h *WSHandler) queueRating(username string, guest bool) int {
+	if guest || h.pg == nil {
+		return rating.Initial
+	}
+	ratings, err := h.pg.PlayerRatings(username)
+	if err != nil {
+		return rating.Initial
+	}
+	if r, ok := ratings[rating.PoolHuman]; ok {
+		return r.Rating
 	}
-	return nil, false, ""
+	return rating.Initial
 }
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"player/backend/internal/game"
+)
+
+func TestWindow(t *testing.T) {
+	tests := []struct {
+		waited time.Duration
+		want   int
+	}{
+		{0, InitialWindow},
+		{500 * time.Millisecond, InitialWindow + WindowGrowth/2},
+		{time.Second, InitialWindow + WindowGrowth},
+		{4 * time.Second, 300},
+		{18 * time.Second, MaxWindow},
+		{time.Minute, MaxWindow},
+	}
+	for _, tt := range tests {
+		if got := window(tt.waited); got != tt.want {
+			t.Errorf("window(%v) = %d, want %d", tt.waited, got, tt.want)
+		}
+	}
+}
+
+type waiting struct {
+	name   string
+	rating int
+	waited time.Duration
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		queue   []waiting // in the order they joined
+		matches []string  // players of each game started, "a-b"
+		left    []string  // still waiting, oldest first
+	}{
+		{"close ratings pair at once",
+			[]waiting{{"a", 1500, 0}, {"b", 1600, 0}},
+			[]string{"a-b"}, nil},
+		{"too far apart at first",
+			[]waiting{{"a", 1500, 0}, {"b", 1700, 0}},
+			nil, []string{"a", "b"}},
+		{"windows widen while waiting",
+			[]waiting{{"a", 1500, 2 * time.Second}, {"b", 1700, 2 * time.Second}},
+			[]string{"a-b"}, nil},
+		{"both windows must reach",
+			[]waiting{{"a", 1500, 8 * time.Second}, {"b", 1700, 0}},
+			nil, []string{"a", "b"}},
+		{"oldest opponent who fits",
+			[]waiting{{"a", 1500, 5 * time.Second}, {"b", 1600, 4 * time.Second}, {"c", 1510, 3 * time.Second}},
+			[]string{"a-b"}, []string{"c"}},
+		{"oldest player who fits anyone",
+			[]waiting{{"a", 1000, 3 * time.Second}, {"b", 1500, 2 * time.Second}, {"c", 1550, time.Second}, {"d", 1560, 0}},
+			[]string{"b-c"}, []string{"a", "d"}},
+		{"several pairs",
+			[]waiting{{"a", 1500, 3 * time.Second}, {"b", 1900, 2 * time.Second}, {"c", 1520, time.Second}, {"d", 1950, 0}},
+			[]string{"a-c", "b-d"}, nil},
+		{"bot after MatchWait",
+			[]waiting{{"a", 1000, MatchWait}, {"b", 1500, time.Second}},
+			[]string{"a-bot"}, []string{"b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMatchmaker()
+			var matches []string
+			m.onMatch = func(mt Match) {
+				matches = append(matches, strings.Join(mt.Players, "-"))
+			}
+			now := time.Now()
+			var tickets []*Ticket
+			for _, w := range tt.queue {
+				tk, err := m.Join(Session{Username: w.name, Rules: game.DefaultRules(), Rating: w.rating})
+				if err != nil {
+					t.Fatal(err)
+				}
+				tk.JoinedAt = now.Add(-w.waited)
+				tickets = append(tickets, tk)
+			}
+			m.match(now)
+			if !reflect.DeepEqual(matches, tt.matches) {
+				t.Errorf("matches %v, want %v", matches, tt.matches)
+			}
+			var left []string
+			for _, tk := range m.queue(QueueKey{Rules: game.DefaultRules()}).waiting {
+				left = append(left, tk.Username)
+			}
+			if !reflect.DeepEqual(left, tt.left) {
+				t.Errorf("left waiting %v, want %v", left, tt.left)
+			}
+			for _, tk := range tickets {
+				select {
+				case mt := <-tk.C:
+					if mt.Opponent(tk.Username) == "" {
+						t.Errorf("%s matched without an opponent", tk.Username)
+					}
+				default:
+				}
+			}
+		})
+	}
+}
+
+func TestJoinAgain(t *testing.T) {
+	m := NewMatchmaker()
+	blitz := game.TimeControl{Initial: 3 * time.Minute}
+	old, err := m.Join(Session{Username: "a", Rules: game.DefaultRules()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Join(Session{Username: "a", Rules: game.DefaultRules(), Time: blitz}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := <-old.C; ok || !errors.Is(old.Err(), ErrQueuedElsewhere) {
+		t.Errorf("old ticket open %v, error %v, want closed with %v", ok, old.Err(), ErrQueuedElsewhere)
+	}
+	for _, key := range []QueueKey{{Rules: game.DefaultRules()}, {Rules: game.DefaultRules(), Time: blitz}} {
+		var names []string
+		for _, tk := range m.queue(key).waiting {
+			names = append(names, tk.Username)
+		}
+		want := []string(nil)
+		if key.Time == blitz {
+			want = []string{"a"}
+		}
+		if !reflect.DeepEqual(names, want) {
+			t.Errorf("queue %v waiting %v, want %v", key.Time, names, want)
+		}
+	}
+}
+
+func TestJoinGuestName(t *testing.T) {
+	tests := []struct {
+		name         string
+		first, again bool // whether each join is a guest's
+	}{
+		{"guest, then guest", true, true},
+		{"account, then guest", false, true},
+		{"guest, then account", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMatchmaker()
+			first, err := m.Join(Session{Username: "a", Rules: game.DefaultRules(), Guest: tt.first})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := m.Join(Session{Username: "a", Rules: game.DefaultRules(), Time: game.TimeControl{Initial: time.Minute}, Guest: tt.again}); !errors.Is(err, ErrNameWaiting) {
+				t.Errorf("second Join() error = %v, want %v", err, ErrNameWaiting)
+			}
+			select {
+			case _, ok := <-first.C:
+				t.Errorf("first ticket got a match or was closed (open %v, error %v)", ok, first.Err())
+			default:
+			}
+			if q := m.queue(QueueKey{Rules: game.DefaultRules()}); len(q.waiting) != 1 || q.waiting[0] != first {
+				t.Errorf("first player lost their place: %v waiting", len(q.waiting))
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"errors"
+
+	"player/backend/internal/bot"
+	"player/backend/internal/game"
+)
+
+var ErrUnknownAction = errors.New("unknown action")
+
+// offerActions are the WebSocket actions for giving up, draws and
+// takebacks, applied to the game for the player who sent them.
+var offerActions = map[string]func(g *game.Game, player int) error{
+	"resign":           (*game.Game).Resign,
+	"offer_draw":       func(g *game.Game, p int) error { return g.MakeOffer(p, game.OfferDraw) },
+	"accept_draw":      func(g *game.Game, p int) error { return g.Accept(p, game.OfferDraw) },
+	"decline_draw":     func(g *game.Game, p int) error { return g.Decline(p, game.OfferDraw) },
+	"request_takeback": func(g *game.Game, p int) error { return g.MakeOffer(p, game.OfferTakeback) },
+	"accept_takeback":  func(g *game.Game, p int) error { return g.Accept(p, game.OfferTakeback) },
+	"decline_takeback": func(g *game.Game, p int) error { return g.Decline(p, game.OfferTakeback) },
+}
+
+// Respond applies a resign, draw or takeback action from username and
+// broadcasts the game to both players. The bot answers offers made to it
+// straight away, going by its evaluation of the position.
+func (h *WSHandler) Respond(gid, username, action string) error {
+	apply, ok := offerActions[action]
+	if !ok {
+		return ErrUnknownAction
+	}
+	g, ok := h.mgr.Get(gid)
+	if !ok {
+		return ErrNoGame
+	}
+	players := h.mgr.GetPlayers(gid)
+	pnum := playerNumber(players, username)
+	if pnum == 0 {
+		return ErrNotInGame
+	}
+	lock := h.mgr.GameLock(gid)
+	lock.Lock()
+	err := apply(g, pnum)
+	snap := g.Clone()
+	lock.Unlock()
+	if err != nil {
+		return err
+	}
+	h.changed(gid, g, snap, players)
+	if o := snap.Offer; o != nil && len(players) == 2 && players[2-o.By] == "bot" {
+		h.botAnswer(gid, g, players, *o)
+	}
+	return nil
+}
+
+// botAnswer has the bot accept or decline offer o. It judges a copy of the
+// game, and only answers if o is still open once it has made up its mind.
+func (h *WSHandler) botAnswer(gid string, g *game.Game, players []string, o game.Offer) {
+	me := 3 - o.By
+	lock := h.mgr.GameLock(gid)
+	lock.Lock()
+	if g.Offer == nil || *g.Offer != o {
+		lock.Unlock()
+		return
+	}
+	pos := g.CloneUntimed()
+	lock.Unlock()
+
+	accept := false
+	switch o.Kind {
+	case game.OfferDraw:
+		accept = bot.AcceptsDraw(pos, me)
+	case game.OfferTakeback:
+		accept = bot.AcceptsTakeback(pos, me)
+	}
+	if snap, ok := h.answer(gid, g, o, accept); ok {
+		h.changed(gid, g, snap, players)
+	}
+}
+
+// answer accepts or declines o for the player it was made to, if it is
+// still open, and returns a copy of the game as that left it.
+func (h *WSHandler) answer(gid string, g *game.Game, o game.Offer, accept bool) (*game.Game, bool) {
+	lock := h.mgr.GameLock(gid)
+	lock.Lock()
+	defer lock.Unlock()
+	if g.Offer == nil || *g.Offer != o {
+		// a move or the end of the game let it lapse meanwhile
+		return nil, false
+	}
+	var err error
+	if accept {
+		err = g.Accept(3-o.By, o.Kind)
+	} else {
+		err = g.Decline(3-o.By, o.Kind)
+	}
+	return g.Clone(), err == nil
+}
+
+// changed broadcasts a game that was updated outside a move, then saves it
+// if that update ended it or rearms its clock if not. snap is a copy of g
+// taken under its lock straight after the update.
+func (h *WSHandler) changed(gid string, g, snap *game.Game, players []string) {
+	h.mgr.Add(g, players...)
+	h.broadcastGame(gid, snap)
+	if snap.Finished {
+		h.finish(gid, snap, players)
+	} else {
+		h.watchClock(gid, g, players)
+	}
+}
@@ -2,6 +2,12 @@ package server
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"player/backend/internal/game"
+	"player/backend/internal/rating"
 
 	_ "github.com/lib/pq"
 )
@@ -31,32 +37,218 @@ CREATE TABLE IF NOT EXISTS games (
 	moves JSONB,
 	created_at TIMESTAMP DEFAULT now()
 );
+ALTER TABLE games ADD COLUMN IF NOT EXISTS rules JSONB;
+ALTER TABLE games ADD COLUMN IF NOT EXISTS hints JSONB;
+ALTER TABLE games ADD COLUMN IF NOT EXISTS ranked BOOLEAN DEFAULT true;
+ALTER TABLE games ADD COLUMN IF NOT EXISTS reason TEXT;
+ALTER TABLE games ADD COLUMN IF NOT EXISTS time_control TEXT;
+ALTER TABLE games ADD COLUMN IF NOT EXISTS imported BOOLEAN DEFAULT false;
+CREATE INDEX IF NOT EXISTS games_player1 ON games (player1);
+CREATE INDEX IF NOT EXISTS games_player2 ON games (player2);
+CREATE TABLE IF NOT EXISTS users (
+	id SERIAL PRIMARY KEY,
+	username VARCHAR(50) UNIQUE NOT NULL,
+	wins INT DEFAULT 0,
+	losses INT DEFAULT 0,
+	games_played INT DEFAULT 0
+);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS password_hash TEXT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS created_at TIMESTAMP DEFAULT now();
 CREATE TABLE IF NOT EXISTS leaderboard (
 	username TEXT PRIMARY KEY,
 	wins INT
 );
+CREATE TABLE IF NOT EXISTS ratings (
+	username TEXT,
+	pool TEXT,
+	rating INT NOT NULL,
+	games INT NOT NULL DEFAULT 0,
+	updated_at TIMESTAMP DEFAULT now(),
+	PRIMARY KEY (username, pool)
+);
+CREATE TABLE IF NOT EXISTS rating_history (
+	id SERIAL PRIMARY KEY,
+	username TEXT NOT NULL,
+	pool TEXT NOT NULL,
+	game_id TEXT,
+	rating_before INT,
+	rating_after INT,
+	created_at TIMESTAMP DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS rating_history_user ON rating_history (username, pool, id);
 `
 	_, err := s.db.Exec(schema)
 	return err
 }
 
-func (s *PGStore) SaveGame(id, p1, p2 string, winner int, moves string) error {
-	_, err := s.db.Exec(`INSERT INTO games (id, player1, player2, winner, moves) VALUES ($1,$2,$3,$4,$5) ON CONFLICT (id) DO NOTHING`, id, p1, p2, winner, moves)
+// SaveGame stores a finished game together with its rules, full move list,
+// time control and why it ended. A ranked game is counted for the
+// leaderboard, the players' records and their ratings in the same
+// transaction, and the rating changes are returned; games where a hint was
+// used or a guest played are stored as unranked and count for none of them.
+func (s *PGStore) SaveGame(g *game.Game, p1, p2, botName string) ([]RatingChange, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if err := saveGame(tx, g, p1, p2, g.Ranked(), false, time.Time{}); err != nil {
+		return nil, err
+	}
+	var changes []RatingChange
+	if g.Ranked() {
+		if changes, err = recordResult(tx, g.ID, p1, p2, g.Winner, botName); err != nil {
+			return nil, err
+		}
+	}
+	return changes, tx.Commit()
+}
+
+// ImportGame stores a finished game read from text notation, dated by its
+// Date tag if it has one. Nothing vouches for how an imported game was
+// played, so it is never ranked and is left out of player statistics and
+// opening books.
+func (s *PGStore) ImportGame(g *game.Game, p1, p2 string, date time.Time) error {
+	return saveGame(s.db, g, p1, p2, false, true, date)
+}
+
+// saveGame inserts a game, dated now unless created is set.
+func saveGame(db execer, g *game.Game, p1, p2 string, ranked, imported bool, created time.Time) error {
+	moves, err := json.Marshal(g.Moves)
+	if err != nil {
+		return err
+	}
+	rules, err := json.Marshal(g.Rules)
+	if err != nil {
+		return err
+	}
+	hints, err := json.Marshal(g.Hints)
+	if err != nil {
+		return err
+	}
+	var tc string
+	if g.Clock != nil {
+		tc = g.Clock.Control.String()
+	}
+	date := sql.NullTime{Time: created, Valid: !created.IsZero()}
+	_, err = db.Exec(`INSERT INTO games (id, player1, player2, winner, moves, rules, hints, ranked, reason, time_control, imported, created_at)
+VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,COALESCE($12, now())) ON CONFLICT (id) DO NOTHING`,
+		g.ID, p1, p2, g.Winner, string(moves), string(rules), string(hints), ranked, g.Reason, tc, imported, date)
 	return err
 }
 
+var ErrGameNotFound = errors.New("game not found")
+
+// GameRecord is a finished game as stored in the games table.
+type GameRecord struct {
+	ID        string      `json:"id"`
+	Player1   string      `json:"player1"`
+	Player2   string      `json:"player2"`
+	Winner    int         `json:"winner"`
+	Rules     game.Rules  `json:"rules"`
+	Moves     []game.Move `json:"moves"`
+	Hints     [2]int      `json:"hints"`                  // hints used by player 1 and player 2
+	Ranked    bool        `json:"ranked"`                 // false when the result does not count towards rankings
+	Reason    string      `json:"reason,omitempty"`       // why the game ended, see the game.End constants
+	Time      string      `json:"time_control,omitempty"` // e.g. "3m+2s", empty when untimed
+	Imported  bool        `json:"imported"`               // sent to POST /games/import rather than played here
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+const gameColumns = `id, player1, player2, winner, moves, rules, hints, ranked, reason, time_control, imported, created_at`
+
+// GetGame loads a stored game. Games saved before rules were recorded are
+// assumed to use the default rules.
+func (s *PGStore) GetGame(id string) (*GameRecord, error) {
+	rec, err := scanGame(s.db.QueryRow(`SELECT `+gameColumns+` FROM games WHERE id=$1`, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrGameNotFound
+	}
+	return rec, err
+}
+
+// EachGame calls fn with every stored game, oldest first, stopping at the
+// first error.
+func (s *PGStore) EachGame(fn func(*GameRecord) error) error {
+	rows, err := s.db.Query(`SELECT ` + gameColumns + ` FROM games ORDER BY created_at`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		rec, err := scanGame(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func scanGame(row interface{ Scan(...any) error }) (*GameRecord, error) {
+	var rec GameRecord
+	var p1, p2 sql.NullString
+	var winner sql.NullInt64
+	var moves, rules, hints []byte
+	var ranked, imported sql.NullBool
+	var reason, tc sql.NullString
+	var created sql.NullTime
+	if err := row.Scan(&rec.ID, &p1, &p2, &winner, &moves, &rules, &hints, &ranked, &reason, &tc, &imported, &created); err != nil {
+		return nil, err
+	}
+	rec.Reason, rec.Time = reason.String, tc.String
+	rec.Ranked = !ranked.Valid || ranked.Bool
+	rec.Imported = imported.Bool
+	if len(hints) > 0 {
+		if err := json.Unmarshal(hints, &rec.Hints); err != nil {
+			return nil, err
+		}
+	}
+	rec.Player1, rec.Player2 = p1.String, p2.String
+	rec.Winner = int(winner.Int64)
+	rec.CreatedAt = created.Time
+	rec.Rules = game.DefaultRules()
+	if len(rules) > 0 {
+		if err := json.Unmarshal(rules, &rec.Rules); err != nil {
+			return nil, err
+		}
+	}
+	rec.Moves = []game.Move{}
+	if len(moves) > 0 {
+		if err := json.Unmarshal(moves, &rec.Moves); err != nil {
+			return nil, err
+		}
+	}
+	return &rec, nil
+}
+
 func (s *PGStore) AddWin(username string) error {
-	_, err := s.db.Exec(`INSERT INTO leaderboard (username, wins) VALUES ($1,1) ON CONFLICT (username) DO UPDATE SET wins=leaderboard.wins+1`, username)
+	return addWin(s.db, username)
+}
+
+func addWin(db execer, username string) error {
+	_, err := db.Exec(`INSERT INTO leaderboard (username, wins) VALUES ($1,1) ON CONFLICT (username) DO UPDATE SET wins=leaderboard.wins+1`, username)
 	return err
 }
 
 type Leader struct {
-	Username string `json:"username"`
-	Wins     int    `json:"wins"`
+	Username    string `json:"username"`
+	Rating      int    `json:"rating"`
+	Games       int    `json:"games"` // rated games in the pool
+	Provisional bool   `json:"provisional"`
+	Wins        int    `json:"wins"`
 }
 
-func (s *PGStore) GetLeaderboard() ([]Leader, error) {
-	rows, err := s.db.Query(`SELECT username, wins FROM leaderboard ORDER BY wins DESC LIMIT 20`)
+// GetLeaderboard ranks the players of a rating pool by rating, established
+// players ahead of provisional ones. Bots, rated as "bot:<level>", are left
+// out here, so callers need not filter them.
+func (s *PGStore) GetLeaderboard(pool string) ([]Leader, error) {
+	rows, err := s.db.Query(`SELECT r.username, r.rating, r.games, COALESCE(l.wins, 0)
+FROM ratings r LEFT JOIN leaderboard l ON l.username = r.username
+WHERE r.pool = $1 AND r.username NOT LIKE 'bot:%'
+ORDER BY r.games < $2, r.rating DESC LIMIT 20`, pool, rating.ProvisionalGames)
 	if err != nil {
 		return nil, err
 	}
@@ -64,11 +256,12 @@ func (s *PGStore) GetLeaderboard() ([]Leader, error) {
 	res := []Leader{}
 	for rows.Next() {
 		var l Leader
-		if err := rows.Scan(&l.Username, &l.Wins); err != nil {
+		if err := rows.Scan(&l.Username, &l.Rating, &l.Games, &l.Wins); err != nil {
 			// Log the error and continue to the next row
 			// This prevents one bad row from failing the whole operation
 			continue
 		}
+		l.Provisional = rating.Provisional(l.Games)
 		res = append(res, l)
 	}
 	return res, nil
@@ -0,0 +1,340 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+
+	"player/backend/internal/game"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// ProtocolVersion is the newest WebSocket protocol the server speaks. A
+// client asks for a version with the protocol query parameter and gets the
+// lower of the two; one that does not ask gets version 0, the original
+// protocol of bare game JSON, {"error": ...} and {"hint": ...} messages.
+const ProtocolVersion = 1
+
+// Server message types.
+const (
+	MsgMatched              = "matched"
+	MsgState                = "state"
+	MsgMove                 = "move"
+	MsgGameOver             = "game_over"
+	MsgError                = "error"
+	MsgOpponentDisconnected = "opponent_disconnected"
+	MsgClock                = "clock"
+	MsgHint                 = "hint"
+	MsgRating               = "rating"     // players' rating changes after a ranked game
+	MsgSpectators           = "spectators" // how many are watching
+	MsgRoom                 = "room"       // the private room the host is waiting in
+	MsgQueued               = "queued"     // the matchmaking queue the player joined
+	MsgRematchOffer         = "rematch_offer"
+	MsgRematchDeclined      = "rematch_declined" // the players are released to the queue
+	MsgSeries               = "series"           // the score of a pair who keep rematching
+)
+
+// Client message types besides hint and the offerActions names, which
+// have no payload either. A client that sees a seq more than one past the
+// last it had has missed something and sends resync to get the whole game
+// again. cancel gives up waiting for an opponent. After a game, rematch
+// offers or accepts another and decline_rematch turns it down.
+const (
+	MsgDrop           = "drop"
+	MsgResync         = "resync"
+	MsgCancel         = "cancel"
+	MsgRematch        = "rematch"
+	MsgDeclineRematch = "decline_rematch"
+)
+
+var ErrBadProtocol = errors.New("bad protocol version")
+var ErrBadMessage = errors.New("malformed message")
+
+// Envelope wraps every message of protocol version 1 and later, both ways.
+// Seq counts the events of a game, so it goes up by one with every message
+// sent to all its players; replies to one player carry the seq of the last
+// event they were sent.
+type Envelope struct {
+	Type            string          `json:"type"`
+	Seq             uint64          `json:"seq"`
+	ProtocolVersion int             `json:"protocol_version"`
+	Payload         json.RawMessage `json:"payload,omitempty"`
+}
+
+// MatchedPayload tells a player a game has been found for them. They need
+// the resume token to get back into the game if their connection drops.
+type MatchedPayload struct {
+	GameID      string     `json:"game_id"`
+	You         int        `json:"you"` // player number, 1 or 2
+	Opponent    string     `json:"opponent"`
+	ResumeToken string     `json:"resume_token"`
+	Game        *game.Game `json:"game"`
+}
+
+// MovePayload is a disc that was dropped. Clients apply it to the game
+// they have; only version 0 clients get the whole game after every move.
+type MovePayload struct {
+	Column int        `json:"column"`
+	Row    int        `json:"row"`
+	Player int        `json:"player"`
+	Move   int        `json:"move"` // number of the move, counting from 1
+	game   *game.Game // for version 0 clients
+}
+
+type GameOverPayload struct {
+	Winner int        `json:"winner"` // 0 for a draw
+	Reason string     `json:"reason"`
+	game   *game.Game // for version 0 clients
+}
+
+type ErrorPayload struct {
+	Message string `json:"message"`
+}
+
+// DisconnectPayload says a player dropped and how long they have to come
+// back before the game is forfeited.
+type DisconnectPayload struct {
+	Username string `json:"username"`
+	GraceMs  int64  `json:"grace_ms"`
+}
+
+// DropPayload is the payload of a drop message from a client.
+type DropPayload struct {
+	Column int `json:"column"`
+}
+
+// message is a server message encoded once for all the clients it goes to.
+type message struct {
+	Type    string
+	Payload json.RawMessage
+	Legacy  []byte // what version 0 clients get instead, nil for nothing
+}
+
+func newMessage(typ string, payload interface{}) message {
+	m := message{Type: typ}
+	m.Payload, _ = json.Marshal(payload)
+	if v := legacy(payload); v != nil {
+		m.Legacy, _ = json.Marshal(v)
+	}
+	return m
+}
+
+// legacyMatched is the game as the original protocol sent it on a match,
+// with the resume token alongside its fields.
+type legacyMatched struct {
+	*game.Game
+	ResumeToken string `json:"resume_token"`
+}
+
+// legacy returns what the original protocol sent in place of payload, or
+// nil if it had nothing like it.
+func legacy(payload interface{}) interface{} {
+	switch p := payload.(type) {
+	case *game.Game:
+		return p
+	case MatchedPayload:
+		// the token is new; older clients ignore a field they do not know
+		return legacyMatched{Game: p.Game, ResumeToken: p.ResumeToken}
+	case MovePayload:
+		// the game_over that follows carries the same game
+		if p.game.Finished {
+			return nil
+		}
+		return p.game
+	case GameOverPayload:
+		return p.game
+	case ErrorPayload:
+		return gin.H{"error": p.Message}
+	case HintResult:
+		return gin.H{"hint": p}
+	}
+	return nil
+}
+
+// client is one player's connection and the protocol version it speaks.
+type client struct {
+	conn    *websocket.Conn
+	version int
+	mu      sync.Mutex // held while writing
+	seq     uint64     // last game event sent
+	in      chan received
+	quit    chan struct{}
+	gid     string        // game the client is playing or watching, guarded by WSHandler.mu
+	requeue chan struct{} // the player has been released from a finished game
+}
+
+// received is a message read from a client, or the error reading it.
+type received struct {
+	cmd command
+	err error
+}
+
+// newClient starts reading from conn in the background, so that messages
+// can be waited for alongside other things on in as well as with read.
+func newClient(conn *websocket.Conn, version int) *client {
+	c := &client{conn: conn, version: version, in: make(chan received), quit: make(chan struct{}), requeue: make(chan struct{}, 1)}
+	go func() {
+		defer close(c.in)
+		for {
+			cmd, err := c.decode()
+			select {
+			case c.in <- received{cmd, err}:
+			case <-c.quit:
+				return
+			}
+			if err != nil && !errors.Is(err, ErrBadMessage) {
+				return
+			}
+		}
+	}()
+	return c
+}
+
+// close closes the connection and stops reading from it.
+func (c *client) close() {
+	close(c.quit)
+	c.conn.Close()
+}
+
+// negotiate picks the protocol version for a client from the version it
+// asked for.
+func negotiate(query string) (int, error) {
+	if query == "" {
+		return 0, nil
+	}
+	v, err := strconv.Atoi(query)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("%w: %q", ErrBadProtocol, query)
+	}
+	if v > ProtocolVersion {
+		v = ProtocolVersion
+	}
+	return v, nil
+}
+
+// send writes m to the client. A seq of 0 replies with the seq of the last
+// event the client was sent.
+func (c *client) send(m message, seq uint64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if seq > c.seq {
+		c.seq = seq
+	}
+	if c.version == 0 {
+		if m.Legacy == nil {
+			return nil
+		}
+		return c.conn.WriteMessage(websocket.TextMessage, m.Legacy)
+	}
+	return c.conn.WriteJSON(Envelope{Type: m.Type, Seq: c.seq, ProtocolVersion: c.version, Payload: m.Payload})
+}
+
+func (c *client) sendError(err error) error {
+	return c.send(newMessage(MsgError, ErrorPayload{Message: err.Error()}), 0)
+}
+
+// command is a client message, whichever protocol version it came in.
+type command struct {
+	Action string
+	Column int
+}
+
+// read waits for the next message from the client. A read error means the
+// connection is gone; a message that cannot be decoded returns
+// ErrBadMessage and the connection can carry on.
+func (c *client) read() (command, error) {
+	r, ok := <-c.in
+	if !ok {
+		return command{}, net.ErrClosed
+	}
+	return r.cmd, r.err
+}
+
+func (c *client) decode() (command, error) {
+	_, data, err := c.conn.ReadMessage()
+	if err != nil {
+		return command{}, err
+	}
+	if c.version == 0 {
+		var msg struct {
+			Action string `json:"action"`
+			Column int    `json:"column"`
+		}
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return command{}, ErrBadMessage
+		}
+		return command{Action: msg.Action, Column: msg.Column}, nil
+	}
+	var env Envelope
+	if err := json.Unmarshal(data, &env); err != nil || env.Type == "" {
+		return command{}, ErrBadMessage
+	}
+	cmd := command{Action: env.Type}
+	if env.Type == MsgDrop {
+		var p DropPayload
+		if err := json.Unmarshal(env.Payload, &p); err != nil {
+			return command{}, ErrBadMessage
+		}
+		cmd.Column = p.Column
+	}
+	return cmd, nil
+}
+
+// broadcast sends a message to every connection of a game as its next event.
+func (h *WSHandler) broadcast(gid, typ string, payload interface{}) {
+	m := newMessage(typ, payload)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.seqs[gid]++
+	h.events[gid] = append(h.events[gid], event{seq: h.seqs[gid], m: m})
+	for _, c := range h.conns[gid] {
+		c.send(m, h.seqs[gid])
+	}
+	for c := range h.watchers[gid] {
+		c.send(m, h.seqs[gid])
+	}
+}
+
+// broadcastGame sends the whole game to its players after a change other
+// than a move, or just the result as game_over once it has finished. g must
+// be a copy taken under the game's lock, like the one broadcastMove gets.
+func (h *WSHandler) broadcastGame(gid string, g *game.Game) {
+	if g.Finished {
+		h.broadcast(gid, MsgGameOver, GameOverPayload{Winner: g.Winner, Reason: g.Reason, game: g})
+		return
+	}
+	h.broadcast(gid, MsgState, g)
+}
+
+// broadcastMove sends a move to the game's players, followed by game_over
+// if it ended the game.
+func (h *WSHandler) broadcastMove(gid string, g *game.Game, col, row, player int) {
+	h.broadcast(gid, MsgMove, MovePayload{Column: col, Row: row, Player: player, Move: len(g.Moves), game: g})
+	if g.Finished {
+		h.broadcastGame(gid, g)
+	}
+}
+
+// snapshot sends a client the whole game as of the latest event, when it
+// joins or asks to resync; first is sent instead when it is set. h.mu must
+// be held so no event can get in between.
+func (h *WSHandler) snapshot(gid string, g *game.Game, cl *client, first message) {
+	if first.Type == "" {
+		first = newMessage(MsgState, h.copyGame(gid, g))
+	}
+	cl.send(first, h.seqs[gid])
+}
+
+// copyGame returns a copy of g taken under its game lock, safe to read and
+// send while moves and timers carry on changing g.
+func (h *WSHandler) copyGame(gid string, g *game.Game) *game.Game {
+	lock := h.mgr.GameLock(gid)
+	lock.Lock()
+	defer lock.Unlock()
+	return g.Clone()
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"database/sql"
+	"time"
+
+	"player/backend/internal/rating"
+)
+
+// execer is what the record keeping queries need from a *sql.DB or *sql.Tx.
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// RatingChange is how one player's rating moved after a game.
+type RatingChange struct {
+	Username    string `json:"username"`
+	Pool        string `json:"pool"`
+	Before      int    `json:"before"`
+	After       int    `json:"after"`
+	Provisional bool   `json:"provisional"`
+}
+
+// ratedName is who a player is in the ratings: the bot is rated per level
+// or personality, as "bot:hard" and so on.
+func ratedName(username, botName string) string {
+	if username == "bot" {
+		return "bot:" + botName
+	}
+	return username
+}
+
+// recordResult counts a ranked game as part of tx: the winner's wins on
+// the leaderboard, both players' account records, and their ratings with a
+// history entry each. Games against the bot are rated in the bot pool, with
+// botName, the engine's level or personality, rated as a player of its own.
+// It returns the rating changes, player 1's first.
+func recordResult(tx *sql.Tx, gameID, p1, p2 string, winner int, botName string) ([]RatingChange, error) {
+	var err error
+	switch winner {
+	case 1:
+		err = addWin(tx, p1)
+	case 2:
+		err = addWin(tx, p2)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := addResult(tx, p1, p2, winner); err != nil {
+		return nil, err
+	}
+
+	pool := rating.PoolHuman
+	if p1 == "bot" || p2 == "bot" {
+		pool = rating.PoolBot
+	}
+	names := [2]string{ratedName(p1, botName), ratedName(p2, botName)}
+	if names[0] == names[1] {
+		return nil, nil
+	}
+	// lock the two rows in a fixed order so concurrent games cannot deadlock
+	first := 0
+	if names[1] < names[0] {
+		first = 1
+	}
+	var r, games [2]int
+	for _, i := range []int{first, 1 - first} {
+		if _, err := tx.Exec(`INSERT INTO ratings (username, pool, rating, games) VALUES ($1,$2,$3,0) ON CONFLICT (username, pool) DO NOTHING`,
+			names[i], pool, rating.Initial); err != nil {
+			return nil, err
+		}
+		if err := tx.QueryRow(`SELECT rating, games FROM ratings WHERE username=$1 AND pool=$2 FOR UPDATE`,
+			names[i], pool).Scan(&r[i], &games[i]); err != nil {
+			return nil, err
+		}
+	}
+	changes := make([]RatingChange, 2)
+	for i := range names {
+		after := rating.Update(r[i], r[1-i], games[i], rating.Score(i+1, winner))
+		changes[i] = RatingChange{Username: names[i], Pool: pool, Before: r[i], After: after, Provisional: rating.Provisional(games[i] + 1)}
+		if _, err := tx.Exec(`UPDATE ratings SET rating=$3, games=games+1, updated_at=now() WHERE username=$1 AND pool=$2`,
+			names[i], pool, after); err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(`INSERT INTO rating_history (username, pool, game_id, rating_before, rating_after) VALUES ($1,$2,$3,$4,$5)`,
+			names[i], pool, gameID, r[i], after); err != nil {
+			return nil, err
+		}
+	}
+	return changes, nil
+}
+
+// RatingEntry is one game's change in a player's rating history.
+type RatingEntry struct {
+	GameID    string    `json:"game_id"`
+	Pool      string    `json:"pool"`
+	Before    int       `json:"before"`
+	After     int       `json:"after"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// RatingHistory returns a player's rating changes in a pool, newest first.
+func (s *PGStore) RatingHistory(username, pool string, limit int) ([]RatingEntry, error) {
+	rows, err := s.db.Query(`SELECT game_id, pool, rating_before, rating_after, created_at FROM rating_history
+WHERE username=$1 AND pool=$2 ORDER BY id DESC LIMIT $3`, username, pool, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := []RatingEntry{}
+	for rows.Next() {
+		var e RatingEntry
+		if err := rows.Scan(&e.GameID, &e.Pool, &e.Before, &e.After, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		res = append(res, e)
+	}
+	return res, rows.Err()
+}
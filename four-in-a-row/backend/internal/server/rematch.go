@@ -0,0 +1,303 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"player/backend/internal/game"
+)
+
+var ErrNoRematch = errors.New("no rematch to offer or accept")
+
+// RematchWindow is how long the players of a finished game have to agree
+// on a rematch before they are released.
+const RematchWindow = 30 * time.Second
+
+// Why a rematch did not happen.
+const (
+	RematchDeclined = "declined"
+	RematchTimeout  = "timeout"
+	RematchLeft     = "left"
+)
+
+// RematchOfferPayload tells the players of a finished game that one of
+// them wants a rematch.
+type RematchOfferPayload struct {
+	By        string `json:"by"`
+	ExpiresMs int64  `json:"expires_ms"`
+}
+
+// RematchDeclinedPayload tells the players there is no rematch, and by
+// whom when somebody turned it down or left.
+type RematchDeclinedPayload struct {
+	By     string `json:"by,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// Series is the running score between two players who keep rematching.
+// Wins are in the order of Players, which is their order in the first game.
+type Series struct {
+	Players []string `json:"players"`
+	Wins    []int    `json:"wins"`
+	Draws   int      `json:"draws"`
+}
+
+// rematch is a finished game whose players may still play again.
+type rematch struct {
+	players   []string
+	offeredBy string
+	timer     *time.Timer
+}
+
+func seriesKey(players []string) string {
+	a, b := players[0], players[1]
+	if b < a {
+		a, b = b, a
+	}
+	return a + "\n" + b
+}
+
+// record counts a finished game in the series.
+func (s *Series) record(players []string, winner int) {
+	switch {
+	case winner == 0:
+		s.Draws++
+	case players[winner-1] == s.Players[0]:
+		s.Wins[0]++
+	default:
+		s.Wins[1]++
+	}
+}
+
+// openRematch gives the players of a finished game RematchWindow to agree
+// on another, and sends them the series score so far.
+func (h *WSHandler) openRematch(gid string, g *game.Game, players []string) {
+	if len(players) != 2 {
+		return
+	}
+	key := seriesKey(players)
+	h.mu.Lock()
+	s := h.series[key]
+	if s == nil {
+		s = &Series{Players: append([]string(nil), players...), Wins: make([]int, 2)}
+		h.series[key] = s
+	}
+	s.record(players, g.Winner)
+	score := *s
+	score.Wins = append([]int(nil), s.Wins...)
+	h.rematches[gid] = &rematch{
+		players: players,
+		timer:   time.AfterFunc(RematchWindow, func() { h.releaseRematch(gid, "", RematchTimeout) }),
+	}
+	var left string
+	for _, p := range players {
+		if p != "bot" && h.conns[gid][p] == nil {
+			left = p
+		}
+	}
+	h.mu.Unlock()
+	h.broadcast(gid, MsgSeries, score)
+	if left != "" {
+		h.releaseRematch(gid, left, RematchLeft)
+	}
+}
+
+// Rematch offers the other player of a finished game a new one with the
+// colors swapped and the same rules, or accepts their offer. The bot
+// always accepts.
+func (h *WSHandler) Rematch(gid, username string) error {
+	h.mu.Lock()
+	rm := h.rematches[gid]
+	if rm == nil || playerNumber(rm.players, username) == 0 {
+		h.mu.Unlock()
+		return ErrNoRematch
+	}
+	switch rm.offeredBy {
+	case username:
+		h.mu.Unlock()
+		return nil
+	case "":
+		if playerNumber(rm.players, "bot") == 0 {
+			rm.offeredBy = username
+			h.mu.Unlock()
+			h.broadcast(gid, MsgRematchOffer, RematchOfferPayload{By: username, ExpiresMs: RematchWindow.Milliseconds()})
+			return nil
+		}
+	}
+	delete(h.rematches, gid)
+	rm.timer.Stop()
+	h.mu.Unlock()
+	h.startRematch(gid, rm.players)
+	return nil
+}
+
+// startRematch starts the next game of a series and moves both players'
+// connections and any spectators to it.
+func (h *WSHandler) startRematch(gid string, players []string) {
+	old, ok := h.mgr.Get(gid)
+	if !ok {
+		return
+	}
+	g, _ := game.NewGameWithRules(old.Rules)
+	if old.Clock != nil {
+		g.SetTimeControl(old.Clock.Control)
+	}
+	g.Casual = old.Casual
+	if code, ok := h.mgr.Room(gid); ok {
+		// a rematch of a private game is just as private
+		h.mgr.SetRoom(g.ID, code)
+	}
+	m := Match{Game: g, Players: []string{players[1], players[0]}}
+	if playerNumber(m.Players, "bot") != 0 {
+		m.Engine = h.mgr.Bot(gid)
+	}
+	h.startMatch(m)
+	ngid := g.ID
+
+	h.mu.Lock()
+	for _, p := range m.Players {
+		cl := h.conns[gid][p]
+		if cl == nil {
+			continue
+		}
+		h.moveTo(cl, m, p)
+	}
+	// spectators follow the series on to the new game
+	if watchers := h.watchers[gid]; len(watchers) > 0 {
+		h.watchers[ngid] = watchers
+		for cl := range watchers {
+			cl.gid = ngid
+			cl.mu.Lock()
+			cl.seq = 0
+			cl.mu.Unlock()
+			h.snapshot(ngid, g, cl, message{})
+		}
+	}
+	delete(h.watchers, gid)
+	// nothing more is broadcast to the old game, so its event log can go
+	delete(h.conns, gid)
+	delete(h.seqs, gid)
+	delete(h.events, gid)
+	delete(h.finished, gid)
+	var score Series
+	if s := h.series[seriesKey(players)]; s != nil {
+		score = *s
+		score.Wins = append([]int(nil), s.Wins...)
+	}
+	h.mu.Unlock()
+	h.mgr.Remove(gid)
+
+	h.broadcast(ngid, MsgSeries, score)
+	if playerNumber(m.Players, "bot") == g.Turn {
+		go h.botTurn(ngid, g, m.Players)
+	}
+}
+
+// releaseRematch ends a finished game's chance of a rematch: the series is
+// over, the game is let go, players still connected go back into the
+// matchmaking queue, and anyone watching is disconnected.
+func (h *WSHandler) releaseRematch(gid, by, reason string) {
+	h.mu.Lock()
+	rm := h.rematches[gid]
+	if rm == nil {
+		h.mu.Unlock()
+		return
+	}
+	delete(h.rematches, gid)
+	rm.timer.Stop()
+	delete(h.series, seriesKey(rm.players))
+	h.mu.Unlock()
+
+	h.broadcast(gid, MsgRematchDeclined, RematchDeclinedPayload{By: by, Reason: reason})
+
+	h.mu.Lock()
+	for _, cl := range h.conns[gid] {
+		// on the original protocol the next game simply arrives in place
+		// of this one, as a game would when they first connected
+		select {
+		case cl.requeue <- struct{}{}:
+		default:
+		}
+	}
+	// spectators have seen rematch_declined; there is nothing left to watch
+	for cl := range h.watchers[gid] {
+		cl.conn.Close()
+	}
+	delete(h.watchers, gid)
+	delete(h.conns, gid)
+	delete(h.seqs, gid)
+	delete(h.events, gid)
+	delete(h.finished, gid)
+	h.mu.Unlock()
+	h.mgr.Remove(gid)
+}
+
+// DeclineRematch turns down a rematch of a finished game, or the chance of
+// one.
+func (h *WSHandler) DeclineRematch(gid, username string) error {
+	h.mu.Lock()
+	rm := h.rematches[gid]
+	h.mu.Unlock()
+	if rm == nil || playerNumber(rm.players, username) == 0 {
+		return ErrNoRematch
+	}
+	h.releaseRematch(gid, username, RematchDeclined)
+	return nil
+}
+
+// moveTo puts username's connection in the game of m and sends them the
+// matched message. The new game's events are numbered from the start
+// again. h.mu must be held.
+func (h *WSHandler) moveTo(cl *client, m Match, username string) {
+	gid := m.Game.ID
+	if h.conns[gid] == nil {
+		h.conns[gid] = make(map[string]*client)
+	}
+	h.conns[gid][username] = cl
+	cl.gid = gid
+	cl.mu.Lock()
+	cl.seq = 0
+	cl.mu.Unlock()
+	h.snapshot(gid, m.Game, cl, newMessage(MsgMatched, MatchedPayload{GameID: gid, You: m.You(username), Opponent: m.Opponent(username), ResumeToken: h.ResumeToken(gid, username), Game: h.copyGame(gid, m.Game)}))
+}
+
+// current returns the game a player's connection is now in, which is not
+// the one it started with after a rematch or going back to the queue; g is
+// kept if that game has been let go.
+func (h *WSHandler) current(cl *client, g *game.Game) (string, *game.Game) {
+	h.mu.Lock()
+	gid := cl.gid
+	h.mu.Unlock()
+	if ng, ok := h.mgr.Get(gid); ok {
+		g = ng
+	}
+	return gid, g
+}
+
+// next waits for a player's next message. A player released from a
+// finished game meanwhile is put back in the queue and carries on in the
+// game they are matched into; if they cancel or leave instead, the
+// connection is done.
+func (h *WSHandler) next(cl *client, s Session) (command, error) {
+	for {
+		select {
+		case <-cl.requeue:
+			match, ok := h.queueFor(cl, s)
+			if !ok {
+				return command{}, net.ErrClosed
+			}
+			h.mu.Lock()
+			h.moveTo(cl, match, s.Username)
+			h.mu.Unlock()
+			if match.Engine != nil && playerNumber(match.Players, "bot") == match.Game.Turn {
+				go h.botTurn(match.Game.ID, match.Game, match.Players)
+			}
+		case r, ok := <-cl.in:
+			if !ok {
+				return command{}, net.ErrClosed
+			}
+			return r.cmd, r.err
+		}
+	}
+}
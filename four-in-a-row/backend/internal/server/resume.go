@@ -0,0 +1,133 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+var ErrBadToken = errors.New("invalid token")
+var ErrTokenRequired = errors.New("resume token required")
+var ErrGameGone = errors.New("game is over or no longer exists")
+
+// Signer makes and checks tokens signed with HMAC-SHA256. A token is its
+// fields joined by newlines, base64url encoded, a dot and the signature.
+type Signer struct {
+	secret []byte
+}
+
+// NewSigner signs with secret, or with a random one if it is empty; tokens
+// from a random secret stop working when the server restarts.
+func NewSigner(secret []byte) *Signer {
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		rand.Read(secret)
+	}
+	return &Signer{secret: secret}
+}
+
+func (s *Signer) Sign(fields ...string) string {
+	body := base64.RawURLEncoding.EncodeToString([]byte(strings.Join(fields, "\n")))
+	return body + "." + base64.RawURLEncoding.EncodeToString(s.mac(body))
+}
+
+// Verify returns the fields of a token if its signature is good and it has
+// n of them.
+func (s *Signer) Verify(token string, n int) ([]string, error) {
+	body, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, ErrBadToken
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, s.mac(body)) {
+		return nil, ErrBadToken
+	}
+	b, err := base64.RawURLEncoding.DecodeString(body)
+	if err != nil {
+		return nil, ErrBadToken
+	}
+	fields := strings.Split(string(b), "\n")
+	if len(fields) != n {
+		return nil, ErrBadToken
+	}
+	return fields, nil
+}
+
+func (s *Signer) mac(body string) []byte {
+	m := hmac.New(sha256.New, s.secret)
+	m.Write([]byte(body))
+	return m.Sum(nil)
+}
+
+// resumeKind tells resume tokens apart from any other token made with the
+// same secret.
+const resumeKind = "resume"
+
+// ResumeToken returns the token username needs to get back into game gid
+// after losing their connection.
+func (h *WSHandler) ResumeToken(gid, username string) string {
+	return h.signer.Sign(resumeKind, gid, username)
+}
+
+// checkResume returns the game a resume token is for, provided it belongs
+// to username.
+func (h *WSHandler) checkResume(token, username string) (string, error) {
+	f, err := h.signer.Verify(token, 3)
+	if err != nil || f[0] != resumeKind || f[2] != username {
+		return "", ErrBadToken
+	}
+	return f[1], nil
+}
+
+// PlayerFor returns who a token sent with a request about game gid belongs
+// to: the account of a session token, or the player a resume token for gid
+// was given to.
+func (h *WSHandler) PlayerFor(gid, token string) (string, error) {
+	if name, err := h.accounts.Authenticate(token); err == nil {
+		return name, nil
+	}
+	f, err := h.signer.Verify(token, 3)
+	if err != nil || f[0] != resumeKind || f[1] != gid {
+		return "", ErrBadToken
+	}
+	return f[2], nil
+}
+
+// SetSecret replaces the key resume and session tokens are signed with, so
+// they stay good across restarts and between servers sharing it.
+func (h *WSHandler) SetSecret(secret []byte) {
+	h.signer = NewSigner(secret)
+	h.accounts = NewAccounts(h.pg, h.signer)
+}
+
+// Accounts returns the accounts that sign players in to this handler.
+func (h *WSHandler) Accounts() *Accounts {
+	return h.accounts
+}
+
+// event is a message broadcast to a game, kept so a player who comes back
+// can be sent what they missed.
+type event struct {
+	seq uint64
+	m   message
+}
+
+// replay sends a client the events of a game after seq and reports whether
+// it could; it cannot once they have been dropped. h.mu must be held.
+func (h *WSHandler) replay(gid string, cl *client, after uint64) bool {
+	events := h.events[gid]
+	last := h.seqs[gid]
+	if after > last || (after < last && (len(events) == 0 || events[0].seq > after+1)) {
+		return false
+	}
+	cl.seq = after
+	for _, e := range events {
+		if e.seq > after {
+			cl.send(e.m, e.seq)
+		}
+	}
+	return true
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignerVerify(t *testing.T) {
+	s := NewSigner([]byte("secret"))
+	good := s.Sign("resume", "g-1", "alice")
+	body, sig, _ := strings.Cut(good, ".")
+	tests := []struct {
+		name  string
+		token string
+		n     int
+		ok    bool
+	}{
+		{"good", good, 3, true},
+		{"wrong field count", good, 2, false},
+		{"tampered signature", body + "." + strings.Repeat("A", len(sig)), 3, false},
+		{"tampered body", s.Sign("resume", "g-2", "alice")[:len(body)] + "." + sig, 3, false},
+		{"other secret", NewSigner([]byte("other")).Sign("resume", "g-1", "alice"), 3, false},
+		{"no signature", body, 3, false},
+		{"not base64", "!!!." + sig, 3, false},
+		{"empty", "", 3, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := s.Verify(tt.token, tt.n)
+			if (err == nil) != tt.ok {
+				t.Fatalf("Verify() = %v, %v, want ok %v", f, err, tt.ok)
+			}
+			if tt.ok && strings.Join(f, ",") != "resume,g-1,alice" {
+				t.Errorf("Verify() fields = %v", f)
+			}
+		})
+	}
+}
+
+func TestTokens(t *testing.T) {
+	h := NewWSHandler(NewManager(), NewMatchmaker(), nil, nil)
+	h.SetSecret([]byte("secret"))
+	resume := h.ResumeToken("g-1", "alice")
+	session := h.accounts.session("alice").Token
+	expired := h.signer.Sign(sessionKind, "alice", strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10))
+
+	t.Run("checkResume", func(t *testing.T) {
+		tests := []struct {
+			name     string
+			token    string
+			username string
+			want     string // game the token is good for, "" if refused
+		}{
+			{"good", resume, "alice", "g-1"},
+			{"wrong player", resume, "bob", ""},
+			{"session token", session, "alice", ""},
+			{"tampered", resume + "x", "alice", ""},
+		}
+		for _, tt := range tests {
+			gid, err := h.checkResume(tt.token, tt.username)
+			if gid != tt.want || (err == nil) != (tt.want != "") {
+				t.Errorf("%s: checkResume() = %q, %v, want %q", tt.name, gid, err, tt.want)
+			}
+		}
+	})
+	t.Run("Authenticate", func(t *testing.T) {
+		tests := []struct {
+			name  string
+			token string
+			want  string // account the token is for, "" if refused
+		}{
+			{"good", session, "alice"},
+			{"expired", expired, ""},
+			{"resume token", resume, ""},
+			{"tampered", session + "x", ""},
+		}
+		for _, tt := range tests {
+			name, err := h.accounts.Authenticate(tt.token)
+			if name != tt.want || (err == nil) != (tt.want != "") {
+				t.Errorf("%s: Authenticate() = %q, %v, want %q", tt.name, name, err, tt.want)
+			}
+		}
+	})
+	t.Run("PlayerFor", func(t *testing.T) {
+		tests := []struct {
+			name  string
+			gid   string
+			token string
+			want  string // player the token is for, "" if refused
+		}{
+			{"resume token", "g-1", resume, "alice"},
+			{"session token", "g-2", session, "alice"},
+			{"wrong game", "g-2", resume, ""},
+			{"expired session", "g-1", expired, ""},
+			{"tampered", "g-1", resume + "x", ""},
+		}
+		for _, tt := range tests {
+			name, err := h.PlayerFor(tt.gid, tt.token)
+			if name != tt.want || (err == nil) != (tt.want != "") {
+				t.Errorf("%s: PlayerFor() = %q, %v, want %q", tt.name, name, err, tt.want)
+			}
+		}
+	})
+}
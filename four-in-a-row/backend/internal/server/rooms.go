@@ -0,0 +1,238 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	mrand "math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"player/backend/internal/game"
+	"player/backend/internal/services"
+)
+
+var ErrRoomNotFound = errors.New("room not found or expired")
+var ErrOwnRoom = errors.New("cannot join your own room")
+var ErrRoomExpired = errors.New("nobody joined the room in time")
+var ErrBadFirst = errors.New(`first must be "host", "guest" or "random"`)
+var ErrRoomProtocol = errors.New("creating a room needs protocol 1")
+
+// RoomTTL is how long a private room waits for someone to join.
+const RoomTTL = 10 * time.Minute
+
+// Who moves first in a private room.
+const (
+	FirstHost   = "host"
+	FirstGuest  = "guest"
+	FirstRandom = "random"
+)
+
+// roomCodeChars leaves out letters and digits that are easy to mix up.
+const roomCodeChars = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+const roomCodeLen = 6
+
+// Room is a private game waiting for the one player who has its code. The
+// host picks the rules and who moves first.
+type Room struct {
+	Code      string           `json:"code"`
+	Host      string           `json:"host"`
+	Rules     game.Rules       `json:"rules"`
+	Time      game.TimeControl `json:"time_control"`
+	First     string           `json:"first"`
+	ExpiresAt time.Time        `json:"expires_at"`
+	guest     bool             // the host is not logged in
+	started   chan roomStart   // gets the game once someone joins
+}
+
+// roomStart is a room's game and its players in the order they play.
+type roomStart struct {
+	g       *game.Game
+	players []string
+}
+
+// Rooms keeps the private rooms waiting for a second player.
+type Rooms struct {
+	mu    sync.Mutex
+	rooms map[string]*Room
+}
+
+func NewRooms() *Rooms {
+	return &Rooms{rooms: make(map[string]*Room)}
+}
+
+// parseFirst reads who moves first in a room; the host does by default.
+func parseFirst(s string) (string, error) {
+	switch s {
+	case "":
+		return FirstHost, nil
+	case FirstHost, FirstGuest, FirstRandom:
+		return s, nil
+	}
+	return "", ErrBadFirst
+}
+
+// Create opens a room for s with a fresh code.
+func (r *Rooms) Create(s Session, first string) *Room {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	code := newRoomCode()
+	for r.rooms[code] != nil {
+		code = newRoomCode()
+	}
+	room := &Room{
+		Code:      code,
+		Host:      s.Username,
+		Rules:     s.Rules,
+		Time:      s.Time,
+		First:     first,
+		ExpiresAt: time.Now().Add(RoomTTL).Truncate(time.Second).UTC(),
+		guest:     s.Guest,
+		started:   make(chan roomStart, 1),
+	}
+	r.rooms[code] = room
+	return room
+}
+
+func newRoomCode() string {
+	b := make([]byte, roomCodeLen)
+	rand.Read(b)
+	for i := range b {
+		b[i] = roomCodeChars[int(b[i])%len(roomCodeChars)]
+	}
+	return string(b)
+}
+
+// Get returns the open room with a code, which is not case sensitive.
+func (r *Rooms) Get(code string) (*Room, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	room, ok := r.rooms[strings.ToUpper(code)]
+	if !ok || time.Now().After(room.ExpiresAt) {
+		return nil, false
+	}
+	return room, true
+}
+
+// Join takes the room with a code for s and returns the new game with its
+// players in order. The room is gone once joined, so only one player can.
+func (r *Rooms) Join(code string, s Session) (*Room, roomStart, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	code = strings.ToUpper(code)
+	room, ok := r.rooms[code]
+	if !ok || time.Now().After(room.ExpiresAt) {
+		return nil, roomStart{}, ErrRoomNotFound
+	}
+	if room.Host == s.Username {
+		return nil, roomStart{}, ErrOwnRoom
+	}
+	delete(r.rooms, code)
+	g, err := game.NewGameWithRules(room.Rules)
+	if err != nil {
+		return nil, roomStart{}, err
+	}
+	g.SetTimeControl(room.Time)
+	g.Casual = room.guest || s.Guest
+	players := []string{room.Host, s.Username}
+	if room.First == FirstGuest || (room.First == FirstRandom && mrand.Intn(2) == 1) {
+		players[0], players[1] = players[1], players[0]
+	}
+	return room, roomStart{g: g, players: players}, nil
+}
+
+// Close removes a room nobody has joined and reports whether it was still
+// open; once it has been joined its game goes ahead.
+func (r *Rooms) Close(room *Room) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.rooms[room.Code] != room {
+		return false
+	}
+	delete(r.rooms, room.Code)
+	return true
+}
+
+// Rooms returns the rooms waiting for players to join.
+func (h *WSHandler) Rooms() *Rooms {
+	return h.rooms
+}
+
+// hostRoom opens a private room for s, sends its code to the host and waits
+// for someone to join. It returns the room's game and the host's matched
+// message, or false if the room expired or the host cancelled or left
+// first; there is no bot to fall back on.
+func (h *WSHandler) hostRoom(cl *client, s Session, first string) (*game.Game, message, bool) {
+	room := h.rooms.Create(s, first)
+	cl.send(newMessage(MsgRoom, room), 0)
+	expire := time.NewTimer(time.Until(room.ExpiresAt))
+	defer expire.Stop()
+	for {
+		select {
+		case st := <-room.started:
+			return st.g, h.matched(st, s.Username), true
+		case <-expire.C:
+			if h.rooms.Close(room) {
+				cl.sendError(ErrRoomExpired)
+				return nil, message{}, false
+			}
+		case r := <-cl.in:
+			if r.err == nil && r.cmd.Action != MsgCancel {
+				continue
+			}
+			if errors.Is(r.err, ErrBadMessage) {
+				cl.sendError(r.err)
+				continue
+			}
+			if h.rooms.Close(room) {
+				return nil, message{}, false
+			}
+		}
+		// joined just as the room expired or the host gave up: the game
+		// is on its way
+		st := <-room.started
+		return st.g, h.matched(st, s.Username), true
+	}
+}
+
+// joinRoom starts the game of the room with a code for s, tells the host
+// and returns the game with the joining player's matched message.
+func (h *WSHandler) joinRoom(code string, s Session) (*game.Game, message, error) {
+	room, st, err := h.rooms.Join(code, s)
+	if err != nil {
+		return nil, message{}, err
+	}
+	gid := st.g.ID
+	// marked before it is added, so it is never listed or watched as public
+	h.mgr.SetRoom(gid, room.Code)
+	h.mgr.Add(st.g, st.players...)
+	h.watchClock(gid, st.g, st.players)
+	if h.kafka != nil {
+		payload, _ := json.Marshal(map[string]interface{}{
+			"game_id":   gid,
+			"players":   st.players,
+			"room":      room.Code,
+			"timestamp": time.Now().UTC(),
+		})
+		h.kafka.Emit(services.EventGameStarted, string(payload))
+	}
+	room.started <- st
+	return st.g, h.matched(st, s.Username), nil
+}
+
+// matched is the matched message for username in a room's game.
+func (h *WSHandler) matched(st roomStart, username string) message {
+	you := 1
+	if st.players[1] == username {
+		you = 2
+	}
+	return newMessage(MsgMatched, MatchedPayload{
+		GameID:      st.g.ID,
+		You:         you,
+		Opponent:    st.players[2-you],
+		ResumeToken: h.ResumeToken(st.g.ID, username),
+		Game:        h.copyGame(st.g.ID, st.g),
+	})
+}
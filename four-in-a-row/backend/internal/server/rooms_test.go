@@ -0,0 +1,106 @@
+package server
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"player/backend/internal/game"
+)
+
+func TestRoomFirst(t *testing.T) {
+	tests := []struct {
+		first string
+		want  []string
+	}{
+		{FirstHost, []string{"alice", "bob"}},
+		{FirstGuest, []string{"bob", "alice"}},
+	}
+	for _, tt := range tests {
+		r := NewRooms()
+		room := r.Create(Session{Username: "alice", Rules: game.DefaultRules()}, tt.first)
+		_, st, err := r.Join(room.Code, Session{Username: "bob"})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.first, err)
+		}
+		if !reflect.DeepEqual(st.players, tt.want) {
+			t.Errorf("%s: players %v, want %v", tt.first, st.players, tt.want)
+		}
+	}
+
+	// a random pick should put each of them first within a few games
+	seen := map[string]bool{}
+	for i := 0; i < 64 && len(seen) < 2; i++ {
+		r := NewRooms()
+		room := r.Create(Session{Username: "alice", Rules: game.DefaultRules()}, FirstRandom)
+		_, st, err := r.Join(room.Code, Session{Username: "bob"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen[st.players[0]] = true
+	}
+	if len(seen) != 2 {
+		t.Errorf("random first always picked %v", seen)
+	}
+}
+
+func TestRoomJoin(t *testing.T) {
+	tests := []struct {
+		name  string
+		code  func(room *Room) string
+		guest string
+		setup func(room *Room)
+		want  error
+	}{
+		{"joined", func(room *Room) string { return room.Code }, "bob", nil, nil},
+		{"code in lower case", func(room *Room) string { return strings.ToLower(room.Code) }, "bob", nil, nil},
+		{"unknown code", func(room *Room) string { return "ZZZZZZ" }, "bob", nil, ErrRoomNotFound},
+		{"own room", func(room *Room) string { return room.Code }, "alice", nil, ErrOwnRoom},
+		{"expired", func(room *Room) string { return room.Code }, "bob",
+			func(room *Room) { room.ExpiresAt = time.Now().Add(-time.Second) }, ErrRoomNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRooms()
+			room := r.Create(Session{Username: "alice", Rules: game.DefaultRules()}, FirstHost)
+			if tt.setup != nil {
+				tt.setup(room)
+			}
+			_, st, err := r.Join(tt.code(room), Session{Username: tt.guest})
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Join() error = %v, want %v", err, tt.want)
+			}
+			_, open := r.Get(room.Code)
+			if err != nil {
+				if tt.want == ErrOwnRoom && !open {
+					t.Error("refusing the host closed their room")
+				}
+				return
+			}
+			if st.g == nil || open {
+				t.Errorf("Join() game %v, room still open %v", st.g, open)
+			}
+			if _, _, err := r.Join(room.Code, Session{Username: "carol"}); !errors.Is(err, ErrRoomNotFound) {
+				t.Errorf("second Join() error = %v, want %v", err, ErrRoomNotFound)
+			}
+		})
+	}
+}
+
+func TestRoomCasual(t *testing.T) {
+	for _, tt := range []struct {
+		host, guest bool
+	}{{false, false}, {true, false}, {false, true}} {
+		r := NewRooms()
+		room := r.Create(Session{Username: "alice", Rules: game.DefaultRules(), Guest: tt.host}, FirstHost)
+		_, st, err := r.Join(room.Code, Session{Username: "bob", Guest: tt.guest})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := tt.host || tt.guest; st.g.Casual != want {
+			t.Errorf("host guest %v, joining guest %v: casual %v, want %v", tt.host, tt.guest, st.g.Casual, want)
+		}
+	}
+}
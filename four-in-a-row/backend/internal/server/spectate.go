@@ -0,0 +1,125 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+
+	"player/backend/internal/game"
+
+	"github.com/gin-gonic/gin"
+)
+
+var ErrSpectator = errors.New("spectators cannot play")
+var ErrPrivateGame = errors.New("a private game can only be watched with its room code")
+
+// SpectatorsPayload is sent to everyone in a game when someone starts or
+// stops watching it.
+type SpectatorsPayload struct {
+	Count int `json:"count"`
+}
+
+// LiveGame is a game in progress that can be watched.
+type LiveGame struct {
+	ID         string     `json:"id"`
+	Players    []string   `json:"players"`
+	Moves      int        `json:"moves"`
+	Rules      game.Rules `json:"rules"`
+	Time       string     `json:"time_control,omitempty"`
+	Spectators int        `json:"spectators"`
+}
+
+// LiveGames lists the games being played right now, most watched first.
+// Games in private rooms are left out.
+func (h *WSHandler) LiveGames() []LiveGame {
+	res := []LiveGame{}
+	for _, gid := range h.mgr.IDs() {
+		if _, private := h.mgr.Room(gid); private {
+			continue
+		}
+		g, ok := h.mgr.Get(gid)
+		if !ok {
+			continue
+		}
+		if g = h.copyGame(gid, g); g.Finished {
+			continue
+		}
+		lg := LiveGame{ID: gid, Players: h.mgr.GetPlayers(gid), Moves: len(g.Moves), Rules: g.Rules}
+		if g.Clock != nil {
+			lg.Time = g.Clock.Control.String()
+		}
+		h.mu.Lock()
+		lg.Spectators = len(h.watchers[gid])
+		h.mu.Unlock()
+		res = append(res, lg)
+	}
+	sort.SliceStable(res, func(i, j int) bool { return res[i].Spectators > res[j].Spectators })
+	return res
+}
+
+// spectate serves a read-only connection to game gid. Spectators get the
+// same events as the players but anything they send other than resync is
+// refused, and leaving costs nobody anything. A game in a private room can
+// only be watched by someone who gives its room code.
+func (h *WSHandler) spectate(c *gin.Context, gid string, version int) {
+	g, ok := h.mgr.Get(gid)
+	if gid == "" || !ok || h.copyGame(gid, g).Finished {
+		c.JSON(http.StatusNotFound, gin.H{"error": ErrGameGone.Error()})
+		return
+	}
+	if code, private := h.mgr.Room(gid); private && !strings.EqualFold(c.Query("room"), code) {
+		c.JSON(http.StatusForbidden, gin.H{"error": ErrPrivateGame.Error()})
+		return
+	}
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return
+	}
+	cl := newClient(conn, version)
+	defer cl.close()
+
+	h.mu.Lock()
+	if h.watchers[gid] == nil {
+		h.watchers[gid] = make(map[*client]bool)
+	}
+	h.watchers[gid][cl] = true
+	cl.gid = gid
+	h.snapshot(gid, g, cl, message{})
+	n := len(h.watchers[gid])
+	h.mu.Unlock()
+	h.broadcast(gid, MsgSpectators, SpectatorsPayload{Count: n})
+
+	for {
+		msg, err := cl.read()
+		if errors.Is(err, ErrBadMessage) {
+			cl.sendError(err)
+			continue
+		}
+		if err != nil {
+			break
+		}
+		// a rematch moves spectators on to the next game
+		gid, g = h.current(cl, g)
+		switch {
+		case msg.Action == MsgResync:
+			h.mu.Lock()
+			h.snapshot(gid, g, cl, message{})
+			h.mu.Unlock()
+		case version > 0:
+			cl.sendError(ErrSpectator)
+		}
+	}
+
+	gid, g = h.current(cl, g)
+	h.mu.Lock()
+	delete(h.watchers[gid], cl)
+	n = len(h.watchers[gid])
+	if n == 0 {
+		delete(h.watchers, gid)
+	}
+	h.mu.Unlock()
+	if !h.copyGame(gid, g).Finished {
+		h.broadcast(gid, MsgSpectators, SpectatorsPayload{Count: n})
+	}
+}
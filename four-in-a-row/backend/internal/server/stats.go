@@ -0,0 +1,222 @@
+package server
+
+import (
+	"database/sql"
+	"time"
+
+	"player/backend/internal/game"
+	"player/backend/internal/rating"
+)
+
+// Record counts results from one player's point of view.
+type Record struct {
+	Played int `json:"played"`
+	Wins   int `json:"wins"`
+	Losses int `json:"losses"`
+	Draws  int `json:"draws"`
+}
+
+func (r *Record) add(result string) {
+	r.Played++
+	switch result {
+	case resultWin:
+		r.Wins++
+	case resultLoss:
+		r.Losses++
+	default:
+		r.Draws++
+	}
+}
+
+// PlayerStats sums up every game a player played here. Casual games count
+// too; Ranked holds the results of the games that moved their rating.
+type PlayerStats struct {
+	Record
+	Forfeits      int     `json:"forfeits"`       // games lost by leaving and not coming back
+	CurrentStreak int     `json:"current_streak"` // wins in a row up to the latest game
+	BestStreak    int     `json:"best_streak"`
+	AvgMoves      float64 `json:"avg_moves"`
+	AvgSeconds    float64 `json:"avg_seconds"` // from the first move to the last, over games with timed moves
+	VsHuman       Record  `json:"vs_human"`
+	VsBot         Record  `json:"vs_bot"`
+	Ranked        Record  `json:"ranked"`
+}
+
+const (
+	resultWin  = "win"
+	resultLoss = "loss"
+	resultDraw = "draw"
+)
+
+// resultFor returns how a stored game went for username, who played in it.
+func resultFor(rec *GameRecord, username string) string {
+	switch {
+	case rec.Winner == 0:
+		return resultDraw
+	case (rec.Winner == 1) == (rec.Player1 == username):
+		return resultWin
+	}
+	return resultLoss
+}
+
+func opponentOf(rec *GameRecord, username string) string {
+	if rec.Player1 == username {
+		return rec.Player2
+	}
+	return rec.Player1
+}
+
+// Stats works out a player's statistics from their games, oldest first.
+func Stats(username string, games []*GameRecord) PlayerStats {
+	var st PlayerStats
+	var moves, timed int
+	var seconds float64
+	for _, rec := range games {
+		result := resultFor(rec, username)
+		st.add(result)
+		if opponentOf(rec, username) == "bot" {
+			st.VsBot.add(result)
+		} else {
+			st.VsHuman.add(result)
+		}
+		if rec.Ranked {
+			st.Ranked.add(result)
+		}
+		if result == resultLoss && rec.Reason == game.EndAbandoned {
+			st.Forfeits++
+		}
+		if result == resultWin {
+			st.CurrentStreak++
+			if st.CurrentStreak > st.BestStreak {
+				st.BestStreak = st.CurrentStreak
+			}
+		} else {
+			st.CurrentStreak = 0
+		}
+		moves += len(rec.Moves)
+		if n := len(rec.Moves); n > 1 && !rec.Moves[0].At.IsZero() {
+			seconds += rec.Moves[n-1].At.Sub(rec.Moves[0].At).Seconds()
+			timed++
+		}
+	}
+	if st.Played > 0 {
+		st.AvgMoves = float64(moves) / float64(st.Played)
+	}
+	if timed > 0 {
+		st.AvgSeconds = seconds / float64(timed)
+	}
+	return st
+}
+
+// RecentGame is one line of a player's recent games.
+type RecentGame struct {
+	ID        string    `json:"id"`
+	Opponent  string    `json:"opponent"`
+	Result    string    `json:"result"` // win, loss or draw
+	Reason    string    `json:"reason,omitempty"`
+	Moves     int       `json:"moves"`
+	Ranked    bool      `json:"ranked"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// PlayerRating is a player's standing in one rating pool.
+type PlayerRating struct {
+	Rating      int  `json:"rating"`
+	Games       int  `json:"games"`
+	Provisional bool `json:"provisional"`
+}
+
+// Profile is what GET /players/:username returns.
+type Profile struct {
+	Username    string                  `json:"username"`
+	Registered  bool                    `json:"registered"`
+	MemberSince *time.Time              `json:"member_since,omitempty"`
+	Ratings     map[string]PlayerRating `json:"ratings"` // by pool
+	Stats       PlayerStats             `json:"stats"`
+	RecentGames []RecentGame            `json:"recent_games"` // newest first
+}
+
+// recentGames is how many games a profile lists.
+const recentGames = 10
+
+// GetProfile puts together a player's profile from their account, ratings
+// and stored games. It returns ErrUserNotFound for a name with neither an
+// account nor any games.
+func (s *PGStore) GetProfile(username string) (*Profile, error) {
+	p := &Profile{Username: username, RecentGames: []RecentGame{}}
+	var since sql.NullTime
+	err := s.db.QueryRow(`SELECT created_at FROM users WHERE username=$1`, username).Scan(&since)
+	switch {
+	case err == nil:
+		p.Registered = true
+		if since.Valid {
+			p.MemberSince = &since.Time
+		}
+	case err != sql.ErrNoRows:
+		return nil, err
+	}
+
+	if p.Ratings, err = s.PlayerRatings(username); err != nil {
+		return nil, err
+	}
+	games, err := s.PlayerGames(username)
+	if err != nil {
+		return nil, err
+	}
+	if !p.Registered && len(games) == 0 {
+		return nil, ErrUserNotFound
+	}
+	p.Stats = Stats(username, games)
+	for i := len(games) - 1; i >= 0 && len(p.RecentGames) < recentGames; i-- {
+		rec := games[i]
+		p.RecentGames = append(p.RecentGames, RecentGame{
+			ID:        rec.ID,
+			Opponent:  opponentOf(rec, username),
+			Result:    resultFor(rec, username),
+			Reason:    rec.Reason,
+			Moves:     len(rec.Moves),
+			Ranked:    rec.Ranked,
+			CreatedAt: rec.CreatedAt,
+		})
+	}
+	return p, nil
+}
+
+// PlayerRatings returns a player's rating in each pool they have played in.
+func (s *PGStore) PlayerRatings(username string) (map[string]PlayerRating, error) {
+	rows, err := s.db.Query(`SELECT pool, rating, games FROM ratings WHERE username=$1`, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := map[string]PlayerRating{}
+	for rows.Next() {
+		var pool string
+		var r PlayerRating
+		if err := rows.Scan(&pool, &r.Rating, &r.Games); err != nil {
+			return nil, err
+		}
+		r.Provisional = rating.Provisional(r.Games)
+		res[pool] = r
+	}
+	return res, rows.Err()
+}
+
+// PlayerGames returns every game username played here, oldest first.
+// Imported games are left out: nothing vouches for who played them.
+func (s *PGStore) PlayerGames(username string) ([]*GameRecord, error) {
+	rows, err := s.db.Query(`SELECT `+gameColumns+` FROM games WHERE (player1=$1 OR player2=$1) AND NOT COALESCE(imported, false) ORDER BY created_at`, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []*GameRecord
+	for rows.Next() {
+		rec, err := scanGame(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, rec)
+	}
+	return res, rows.Err()
+}
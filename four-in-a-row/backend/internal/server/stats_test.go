@@ -0,0 +1,106 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"player/backend/internal/game"
+)
+
+// rec is a stored game of moves discs played over secs seconds, or without
+// move times if secs is negative.
+func rec(p1, p2 string, winner int, reason string, ranked bool, moves, secs int) *GameRecord {
+	r := &GameRecord{Player1: p1, Player2: p2, Winner: winner, Reason: reason, Ranked: ranked}
+	start := time.Date(2025, 10, 20, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < moves; i++ {
+		m := game.Move{Player: i%2 + 1, Column: 3}
+		if secs >= 0 {
+			m.At = start.Add(time.Duration(secs*i/max(moves-1, 1)) * time.Second)
+		}
+		r.Moves = append(r.Moves, m)
+	}
+	return r
+}
+
+func TestStats(t *testing.T) {
+	tests := []struct {
+		name  string
+		games []*GameRecord // oldest first
+		want  PlayerStats
+	}{
+		{"no games", nil, PlayerStats{}},
+		{"streaks",
+			[]*GameRecord{
+				rec("alice", "bob", 1, game.EndLine, true, 7, -1),
+				rec("bob", "alice", 2, game.EndLine, true, 8, -1),
+				rec("alice", "bob", 2, game.EndLine, true, 8, -1),
+				rec("alice", "bob", 1, game.EndLine, true, 7, -1),
+				rec("bob", "alice", 2, game.EndLine, true, 8, -1),
+				rec("alice", "bob", 1, game.EndLine, true, 7, -1),
+				rec("bob", "alice", 0, game.EndBoardFull, true, 42, -1),
+				rec("alice", "bob", 1, game.EndLine, true, 7, -1),
+			},
+			PlayerStats{
+				Record:        Record{Played: 8, Wins: 6, Losses: 1, Draws: 1},
+				CurrentStreak: 1,
+				BestStreak:    3,
+				AvgMoves:      94.0 / 8,
+				VsHuman:       Record{Played: 8, Wins: 6, Losses: 1, Draws: 1},
+				Ranked:        Record{Played: 8, Wins: 6, Losses: 1, Draws: 1},
+			}},
+		{"forfeits are losses by leaving",
+			[]*GameRecord{
+				rec("alice", "bob", 2, game.EndAbandoned, true, 2, -1),
+				rec("bob", "alice", 2, game.EndAbandoned, true, 2, -1),
+				rec("alice", "bob", 2, game.EndResigned, true, 2, -1),
+				rec("alice", "bob", 2, game.EndTimeout, true, 2, -1),
+			},
+			PlayerStats{
+				Record:        Record{Played: 4, Wins: 1, Losses: 3},
+				Forfeits:      1,
+				CurrentStreak: 0,
+				BestStreak:    1,
+				AvgMoves:      2,
+				VsHuman:       Record{Played: 4, Wins: 1, Losses: 3},
+				Ranked:        Record{Played: 4, Wins: 1, Losses: 3},
+			}},
+		{"bot and people apart, ranked only when rated",
+			[]*GameRecord{
+				rec("alice", "bot", 1, game.EndLine, true, 7, -1),
+				rec("bot", "alice", 1, game.EndLine, false, 7, -1),
+				rec("alice", "bob", 0, game.EndDrawAgreed, false, 4, -1),
+				rec("carol", "alice", 1, game.EndLine, true, 7, -1),
+			},
+			PlayerStats{
+				Record:     Record{Played: 4, Wins: 1, Losses: 2, Draws: 1},
+				BestStreak: 1,
+				AvgMoves:   25.0 / 4,
+				VsHuman:    Record{Played: 2, Losses: 1, Draws: 1},
+				VsBot:      Record{Played: 2, Wins: 1, Losses: 1},
+				Ranked:     Record{Played: 2, Wins: 1, Losses: 1},
+			}},
+		{"average length over timed games only",
+			[]*GameRecord{
+				rec("alice", "bob", 1, game.EndLine, true, 7, 60),
+				rec("alice", "bob", 1, game.EndLine, true, 11, 120),
+				rec("alice", "bob", 1, game.EndLine, true, 9, -1),
+				rec("alice", "bob", 2, game.EndResigned, true, 1, 0),
+			},
+			PlayerStats{
+				Record:     Record{Played: 4, Wins: 3, Losses: 1},
+				BestStreak: 3,
+				AvgMoves:   7,
+				AvgSeconds: 90,
+				VsHuman:    Record{Played: 4, Wins: 3, Losses: 1},
+				Ranked:     Record{Played: 4, Wins: 3, Losses: 1},
+			}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Stats("alice", tt.games); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Stats() = %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,10 +2,15 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 
+	"player/backend/internal/bot"
 	"player/backend/internal/game"
 	"player/backend/internal/services"
 
@@ -15,157 +20,290 @@ import (
 
 var upgrader = websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
 
+// disconnectGrace is how long a player who drops has to reconnect before
+// they forfeit the game.
+const disconnectGrace = 30 * time.Second
+
 type WSHandler struct {
 	mgr   *Manager
 	mm    *Matchmaker
 	pg    *PGStore
 	kafka *KafkaProducer
-	// conns: gameID -> username -> conn
-	conns map[string]map[string]*websocket.Conn
+	// conns: gameID -> username -> client
+	conns map[string]map[string]*client
+	// seqs: gameID -> seq of the last event broadcast
+	seqs map[string]uint64
 	// disconnect timers: gameID -> username -> timer
 	timers map[string]map[string]*time.Timer
-	mu     sync.Mutex
+	// flag timers: gameID -> timer for the player to move running out of time
+	flags map[string]*time.Timer
+	// events: gameID -> events broadcast so far, for players who resume
+	events map[string][]event
+	// finished: gameIDs already saved and scored by finish
+	finished map[string]bool
+	// watchers: gameID -> spectators' clients
+	watchers map[string]map[*client]bool
+	rooms    *Rooms
+	// rematches: gameID of a finished game -> its players' rematch
+	rematches map[string]*rematch
+	// series: the pair of players -> their score while they keep rematching
+	series   map[string]*Series
+	signer   *Signer
+	accounts *Accounts
+	mu       sync.Mutex
 }
 
 func NewWSHandler(mgr *Manager, mm *Matchmaker, pg *PGStore, kafka *KafkaProducer) *WSHandler {
-	return &WSHandler{
-		mgr:    mgr,
-		mm:     mm,
-		pg:     pg,
-		kafka:  kafka,
-		conns:  make(map[string]map[string]*websocket.Conn),
-		timers: make(map[string]map[string]*time.Timer),
+	signer := NewSigner(nil)
+	h := &WSHandler{
+		mgr:       mgr,
+		mm:        mm,
+		pg:        pg,
+		kafka:     kafka,
+		conns:     make(map[string]map[string]*client),
+		seqs:      make(map[string]uint64),
+		timers:    make(map[string]map[string]*time.Timer),
+		flags:     make(map[string]*time.Timer),
+		events:    make(map[string][]event),
+		finished:  make(map[string]bool),
+		watchers:  make(map[string]map[*client]bool),
+		rooms:     NewRooms(),
+		rematches: make(map[string]*rematch),
+		series:    make(map[string]*Series),
+		signer:    signer,
+		accounts:  NewAccounts(pg, signer),
 	}
+	// the matchmaker hands each game it starts to this handler
+	mm.mu.Lock()
+	mm.onMatch = h.startMatch
+	mm.mu.Unlock()
+	return h
 }
 
 func (h *WSHandler) Handle(c *gin.Context) {
+	// Anyone can watch a game without saying who they are.
+	if c.Query("spectate") == "1" {
+		version, err := negotiate(c.Query("protocol"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		h.spectate(c, c.Query("gameID"), version)
+		return
+	}
+	// A session token says who the player is; without one they play as a
+	// guest under the username they give, and their games are unranked.
 	username := c.Query("username")
 	gameID := c.Query("gameID")
-	if username == "" {
+	guest := true
+	if token := BearerToken(c); token != "" {
+		name, err := h.accounts.Authenticate(token)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		username, guest = name, false
+	} else if username == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "username required"})
 		return
+	} else if err := h.accounts.CheckGuest(username); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, ErrBadUsername) {
+			status = http.StatusBadRequest
+		} else if errors.Is(err, ErrRegistered) || errors.Is(err, ErrReservedName) {
+			status = http.StatusForbidden
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
 	}
-	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	rules, err := rulesFromQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	tc, err := game.ParseTimeControl(c.Query("time"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// difficulty or personality used if matchmaking falls back to a bot
+	botName, err := bot.ParseName(c.Query("level"))
 	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	version, err := negotiate(c.Query("protocol"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	defer conn.Close()
 
-	// Try to find existing game for this user
+	// Getting back into a game takes the resume token the player was given
+	// when it started; without one they are matched into a new game.
 	var g *game.Game
 	var gid string
 	var found bool
-	if gameID != "" {
-		g, found = h.mgr.Get(gameID)
-		gid = gameID
-	} else {
-		g, gid, found = h.mgr.GetGameByPlayer(username)
+	var lastSeq uint64
+	resuming := c.Query("resume") != ""
+	if resuming {
+		gid, err = h.checkResume(c.Query("resume"), username)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		if gameID != "" && gameID != gid {
+			c.JSON(http.StatusForbidden, gin.H{"error": ErrBadToken.Error()})
+			return
+		}
+		if g, found = h.mgr.Get(gid); !found || h.copyGame(gid, g).Finished {
+			c.JSON(http.StatusGone, gin.H{"error": ErrGameGone.Error()})
+			return
+		}
+		// with last_seq the player is sent the events they missed, else the
+		// whole game
+		if v := c.Query("last_seq"); v != "" {
+			if lastSeq, err = strconv.ParseUint(v, 10, 64); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid last_seq: " + v})
+				return
+			}
+		}
+	} else if gameID != "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": ErrTokenRequired.Error()})
+		return
 	}
 
-	if !found || g == nil || g.Finished {
-		// Not found or finished, matchmake
-		g, createdWithBot, other := h.mm.AddWaiting(username, 10*time.Second)
-		if createdWithBot {
-			h.mgr.Add(g, username, "bot")
-			gid = g.ID
-			// Emit game started event
-			if h.kafka != nil {
-				payload, _ := json.Marshal(map[string]interface{}{
-					"game_id":   gid,
-					"players":   h.mgr.GetPlayers(gid),
-					"timestamp": time.Now().UTC(),
-				})
-				h.kafka.Emit(services.EventGameStarted, string(payload))
-			}
-			// Immediately send new game state to client after bot joins
-			conn.WriteJSON(g)
-			// If bot is player 2 and it's bot's turn, make bot move after 1s delay
-			players := h.mgr.GetPlayers(gid)
-			if len(players) == 2 && players[1] == "bot" && g.Turn == 2 && !g.Finished {
-				go func() {
-					time.Sleep(1 * time.Second)
-					importBotMove := func() int {
-						// Import BotMove from server/bot.go
-						return BotMove(g, 2)
-					}
-					col := importBotMove()
-					r, err := g.Drop(col, 2)
-					if err == nil {
-						// check win
-						if g.CheckWin(r, col, 2) {
-							g.Finished = true
-							g.Winner = 2
-						} else if g.IsFull() {
-							g.Finished = true
-							g.Winner = 0
-						}
-						h.mgr.Add(g, players...)
-						// Broadcast bot move to all clients
-						h.mu.Lock()
-						for _, c := range h.conns[gid] {
-							c.WriteJSON(g)
-						}
-						h.mu.Unlock()
-					}
-				}()
-			}
-		} else if other != "" {
-			h.mgr.Add(g, username, other)
-			gid = g.ID
-			// Emit game started event
-			if h.kafka != nil {
-				payload, _ := json.Marshal(map[string]interface{}{
-					"game_id":   gid,
-					"players":   h.mgr.GetPlayers(gid),
-					"timestamp": time.Now().UTC(),
-				})
-				h.kafka.Emit(services.EventGameStarted, string(payload))
-			}
+	// A private room is opened with room=new, and the rules and first (who
+	// moves first) it is given, then joined with its code. Nobody else is
+	// matched into it.
+	roomCode := c.Query("room")
+	var order string
+	if roomCode == "new" && !resuming {
+		if version == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": ErrRoomProtocol.Error()})
+			return
+		}
+		if order, err = parseFirst(c.Query("first")); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	} else if roomCode != "" && !resuming {
+		room, ok := h.rooms.Get(roomCode)
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": ErrRoomNotFound.Error()})
+			return
+		}
+		if room.Host == username {
+			c.JSON(http.StatusConflict, gin.H{"error": ErrOwnRoom.Error()})
+			return
+		}
+	}
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return
+	}
+	cl := newClient(conn, version)
+	defer cl.close()
+
+	// a new game starts with matched, a resumed one with state
+	var first message
+	session := Session{Username: username, Rules: rules, Time: tc, Bot: botName, Guest: guest, Rating: h.queueRating(username, guest)}
+	switch {
+	case resuming:
+	case roomCode == "new":
+		var ok bool
+		if g, first, ok = h.hostRoom(cl, session, order); !ok {
+			return
+		}
+		gid = g.ID
+	case roomCode != "":
+		if g, first, err = h.joinRoom(roomCode, session); err != nil {
+			// someone else took the room since it was looked up
+			cl.sendError(err)
+			return
+		}
+		gid = g.ID
+	default:
+		match, ok := h.queueFor(cl, session)
+		if !ok {
+			return
+		}
+		g, gid = match.Game, match.Game.ID
+		// the opponent may be moving already
+		snap := h.copyGame(gid, g)
+		first = newMessage(MsgMatched, MatchedPayload{GameID: gid, You: match.You(username), Opponent: match.Opponent(username), ResumeToken: h.ResumeToken(gid, username), Game: snap})
+		// the bot moves after a short pause if it is its turn
+		if match.Engine != nil && playerNumber(match.Players, "bot") == snap.Turn && !snap.Finished {
+			go h.botTurn(gid, g, match.Players)
 		}
 	}
 
 	// Register connection
 	h.mu.Lock()
 	if h.conns[gid] == nil {
-		h.conns[gid] = make(map[string]*websocket.Conn)
+		h.conns[gid] = make(map[string]*client)
 	}
-	h.conns[gid][username] = conn
+	h.conns[gid][username] = cl
+	cl.gid = gid
 	// Cancel disconnect timer if present
 	if h.timers[gid] != nil && h.timers[gid][username] != nil {
 		h.timers[gid][username].Stop()
 		delete(h.timers[gid], username)
 	}
-	h.mu.Unlock()
-
-	// Send initial state
-	conn.WriteJSON(g)
-
-	// Broadcast helper
-	broadcast := func(g *game.Game) {
-		h.mu.Lock()
-		defer h.mu.Unlock()
-		for _, c := range h.conns[gid] {
-			c.WriteJSON(g)
+	// Send initial state, or what was missed since last_seq
+	if resuming && c.Query("last_seq") != "" && h.replay(gid, cl, lastSeq) {
+		lock := h.mgr.GameLock(gid)
+		lock.Lock()
+		clock := g.Clone().Clock
+		lock.Unlock()
+		if clock != nil {
+			// the clocks in the replayed events are out of date by now
+			cl.send(newMessage(MsgClock, clock), 0)
 		}
+	} else {
+		h.snapshot(gid, g, cl, first)
 	}
+	h.mu.Unlock()
 
 	// read loop
 	for {
-		var msg struct {
-			Action string `json:"action"`
-			Column int    `json:"column"`
+		msg, err := h.next(cl, session)
+		if errors.Is(err, ErrBadMessage) {
+			cl.sendError(err)
+			continue
 		}
-		if err := conn.ReadJSON(&msg); err != nil {
+		if err != nil {
 			break
 		}
-		if msg.Action == "drop" && g != nil && !g.Finished {
+		gid, g = h.current(cl, g)
+		switch _, offer := offerActions[msg.Action]; {
+		case msg.Action == MsgDrop:
+			if g == nil {
+				if version > 0 {
+					cl.sendError(game.ErrGameOver)
+				}
+				continue
+			}
 			// Determine player number
 			pnum := 1
 			players := h.mgr.GetPlayers(gid)
 			if len(players) == 2 && players[1] == username {
 				pnum = 2
 			}
+			lock := h.mgr.GameLock(gid)
+			lock.Lock()
+			if g.Finished {
+				lock.Unlock()
+				if version > 0 {
+					cl.sendError(game.ErrGameOver)
+				}
+				continue
+			}
 			r, err := g.Drop(msg.Column, pnum)
+			// the messages are built from a copy, as the opponent or the
+			// clock may change the game as soon as the lock is let go
+			snap := g.Clone()
+			lock.Unlock()
 			if err == nil {
 				// Emit move event
 				if h.kafka != nil {
@@ -178,122 +316,216 @@ func (h *WSHandler) Handle(c *gin.Context) {
 					})
 					h.kafka.Emit(services.EventMoveMade, string(payload))
 				}
-				// check win
-				if g.CheckWin(r, msg.Column, pnum) {
-					g.Finished = true
-					g.Winner = pnum
-				} else if g.IsFull() {
-					g.Finished = true
-					g.Winner = 0
-				}
 				h.mgr.Add(g, players...)
-				broadcast(g)
+				h.broadcastMove(gid, snap, msg.Column, r, pnum)
 				// Persist completed game and update leaderboard
-				if g.Finished {
-					moves := "[]" // TODO: store moves if needed
-					p1, p2 := players[0], ""
-					if len(players) > 1 {
-						p2 = players[1]
-					}
-					if h.pg != nil {
-						h.pg.SaveGame(g.ID, p1, p2, g.Winner, moves)
-						if g.Winner == 1 {
-							h.pg.AddWin(p1)
-						} else if g.Winner == 2 {
-							h.pg.AddWin(p2)
-						}
-					}
-					// Emit game finished event
-					if h.kafka != nil {
-						payload, _ := json.Marshal(map[string]interface{}{
-							"game_id":   gid,
-							"winner":    g.Winner,
-							"players":   players,
-							"timestamp": time.Now().UTC(),
-						})
-						h.kafka.Emit(services.EventGameFinished, string(payload))
-					}
+				if snap.Finished {
+					h.finish(gid, snap, players)
 				} else {
-					// If bot is player 2 and it's now bot's turn, make bot move after 1s delay
-					if len(players) == 2 && players[1] == "bot" && g.Turn == 2 && !g.Finished {
-						go func() {
-							time.Sleep(1 * time.Second)
-							col := BotMove(g, 2)
-							r, err := g.Drop(col, 2)
-							if err == nil {
-								// check win
-								if g.CheckWin(r, col, 2) {
-									g.Finished = true
-									g.Winner = 2
-								} else if g.IsFull() {
-									g.Finished = true
-									g.Winner = 0
-								}
-								h.mgr.Add(g, players...)
-								broadcast(g)
-								// Persist completed game and update leaderboard
-								if g.Finished {
-									moves := "[]" // TODO: store moves if needed
-									p1, p2 := players[0], ""
-									if len(players) > 1 {
-										p2 = players[1]
-									}
-									if h.pg != nil {
-										h.pg.SaveGame(g.ID, p1, p2, g.Winner, moves)
-										if g.Winner == 1 {
-											h.pg.AddWin(p1)
-										} else if g.Winner == 2 {
-											h.pg.AddWin(p2)
-										}
-									}
-									// Emit game finished event
-									if h.kafka != nil {
-										payload, _ := json.Marshal(map[string]interface{}{
-											"game_id":   gid,
-											"winner":    g.Winner,
-											"players":   players,
-											"timestamp": time.Now().UTC(),
-										})
-										h.kafka.Emit(services.EventGameFinished, string(payload))
-									}
-								}
-							}
-						}()
+					h.watchClock(gid, g, players)
+					// If it's now the bot's turn, make bot move after 1s delay
+					if playerNumber(players, "bot") == snap.Turn {
+						go h.botTurn(gid, g, players)
 					}
 				}
+			} else if errors.Is(err, game.ErrFlagFall) {
+				// the move came too late; the game is lost on time
+				h.mgr.Add(g, players...)
+				h.broadcastGame(gid, snap)
+				h.finish(gid, snap, players)
 			} else {
-				conn.WriteJSON(gin.H{"error": err.Error()})
+				cl.sendError(err)
+			}
+		case offer:
+			if err := h.Respond(gid, username, msg.Action); err != nil {
+				cl.sendError(err)
 			}
+		case msg.Action == MsgResync:
+			h.mu.Lock()
+			h.snapshot(gid, g, cl, message{})
+			h.mu.Unlock()
+		case msg.Action == MsgRematch:
+			if err := h.Rematch(gid, username); err != nil {
+				cl.sendError(err)
+			}
+		case msg.Action == MsgDeclineRematch:
+			if err := h.DeclineRematch(gid, username); err != nil {
+				cl.sendError(err)
+			}
+		case msg.Action == MsgHint:
+			res, err := h.Hint(gid, username)
+			if err != nil {
+				cl.sendError(err)
+				continue
+			}
+			cl.send(newMessage(MsgHint, res), 0)
+		case version > 0:
+			// the original protocol ignored actions it did not know
+			cl.sendError(ErrUnknownAction)
 		}
 	}
 
-	// On disconnect, start 30s timer
+	// On disconnect, start the forfeit timer, unless the player has already
+	// reconnected on another connection
+	gid, g = h.current(cl, g)
 	h.mu.Lock()
+	if h.conns[gid][username] != cl {
+		h.mu.Unlock()
+		return
+	}
 	if h.timers[gid] == nil {
 		h.timers[gid] = make(map[string]*time.Timer)
 	}
-	h.timers[gid][username] = time.AfterFunc(30*time.Second, func() {
+	h.timers[gid][username] = time.AfterFunc(disconnectGrace, func() {
 		// Forfeit if not reconnected
 		h.mu.Lock()
-		defer h.mu.Unlock()
-		if h.conns[gid][username] == nil && g != nil && !g.Finished {
-			g.Finished = true
-			// Opponent wins
-			players := h.mgr.GetPlayers(gid)
-			winner := 1
-			if len(players) == 2 && players[1] == username {
-				winner = 1
-			} else {
-				winner = 2
-			}
-			g.Winner = winner
-			h.mgr.Add(g, players...)
-			for _, c := range h.conns[gid] {
-				c.WriteJSON(g)
-			}
+		gone := h.conns[gid][username] == nil
+		h.mu.Unlock()
+		if !gone || g == nil {
+			return
+		}
+		// Opponent wins
+		players := h.mgr.GetPlayers(gid)
+		loser := 1
+		if len(players) == 2 && players[1] == username {
+			loser = 2
 		}
+		lock := h.mgr.GameLock(gid)
+		lock.Lock()
+		if g.Finished {
+			lock.Unlock()
+			return
+		}
+		g.Abandon(loser)
+		snap := g.Clone()
+		lock.Unlock()
+		h.mgr.Add(g, players...)
+		h.broadcastGame(gid, snap)
+		h.finish(gid, snap, players)
 	})
 	// Remove connection
 	delete(h.conns[gid], username)
 	h.mu.Unlock()
+	if g != nil && !h.copyGame(gid, g).Finished {
+		h.broadcast(gid, MsgOpponentDisconnected, DisconnectPayload{Username: username, GraceMs: disconnectGrace.Milliseconds()})
+	} else {
+		h.releaseRematch(gid, username, RematchLeft)
+	}
+}
+
+// rulesFromQuery reads optional rows, cols and connect query parameters,
+// falling back to the default rules for any that are missing.
+func rulesFromQuery(c *gin.Context) (game.Rules, error) {
+	rules := game.DefaultRules()
+	for _, p := range []struct {
+		name string
+		dst  *int
+	}{{"rows", &rules.Rows}, {"cols", &rules.Cols}, {"connect", &rules.Connect}} {
+		v := c.Query(p.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return rules, fmt.Errorf("invalid %s: %q", p.name, v)
+		}
+		*p.dst = n
+	}
+	return rules, rules.Validate()
+}
+
+// botTurn plays the bot's move and broadcasts the result. In an untimed
+// game it pauses first; on a clock it thinks for no longer than its time
+// allows. The engine searches a copy of the game; the move is only played
+// if nobody changed the game meanwhile.
+func (h *WSHandler) botTurn(gid string, g *game.Game, players []string) {
+	if g.Clock == nil {
+		time.Sleep(1 * time.Second)
+	}
+	engine := h.mgr.Bot(gid)
+	pnum := playerNumber(players, "bot")
+	lock := h.mgr.GameLock(gid)
+	lock.Lock()
+	if g.Finished || g.Turn != pnum {
+		lock.Unlock()
+		return
+	}
+	pos, ply := g.CloneUntimed(), len(g.Moves)
+	if g.Clock != nil {
+		engine = bot.WithBudget(engine, botBudget(g.Clock.Control, g.Clock.Left(pnum, time.Now())))
+	}
+	lock.Unlock()
+
+	col := engine.Move(pos)
+
+	lock.Lock()
+	if len(g.Moves) != ply {
+		// a takeback or the end of the game got in first
+		lock.Unlock()
+		return
+	}
+	r, err := g.Drop(col, pnum)
+	snap := g.Clone()
+	lock.Unlock()
+	if errors.Is(err, game.ErrFlagFall) {
+		h.mgr.Add(g, players...)
+		h.broadcastGame(gid, snap)
+		h.finish(gid, snap, players)
+		return
+	}
+	if err != nil {
+		log.Printf("bot %s in game %s played column %d: %v", engine.Name(), gid, col, err)
+		return
+	}
+	h.mgr.Add(g, players...)
+	// Broadcast bot move to all clients
+	h.broadcastMove(gid, snap, col, r, pnum)
+	// Persist completed game and update leaderboard
+	if snap.Finished {
+		h.finish(gid, snap, players)
+	} else {
+		h.watchClock(gid, g, players)
+	}
+}
+
+// finish persists a completed game, updates the leaderboard and emits the
+// game finished event. It only does so once per game, however many of a
+// winning move, a flag fall, a forfeit or an accepted offer race to end it.
+func (h *WSHandler) finish(gid string, g *game.Game, players []string) {
+	h.mu.Lock()
+	if h.finished[gid] {
+		h.mu.Unlock()
+		return
+	}
+	h.finished[gid] = true
+	// nobody can resume a finished game, so its events are no use now
+	delete(h.events, gid)
+	h.mu.Unlock()
+	h.stopClock(gid)
+	p1, p2 := players[0], ""
+	if len(players) > 1 {
+		p2 = players[1]
+	}
+	if h.pg != nil {
+		// the game and, if it is ranked, its result are saved together, so
+		// ratings never count a game that was not stored
+		changes, err := h.pg.SaveGame(g, p1, p2, h.mgr.Bot(gid).Name())
+		if err != nil {
+			log.Printf("save game %s: %v", gid, err)
+		} else if changes != nil {
+			h.broadcast(gid, MsgRating, changes)
+		}
+	}
+	// Emit game finished event
+	if h.kafka != nil {
+		payload, _ := json.Marshal(map[string]interface{}{
+			"game_id":   gid,
+			"winner":    g.Winner,
+			"reason":    g.Reason,
+			"players":   players,
+			"timestamp": time.Now().UTC(),
+		})
+		h.kafka.Emit(services.EventGameFinished, string(payload))
+	}
+	h.openRematch(gid, g, players)
 }
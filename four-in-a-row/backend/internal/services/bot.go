@@ -1,14 +1,23 @@
 package services
 
-import "player/backend/internal/game"
+import (
+	"player/backend/internal/bot"
+	"player/backend/internal/game"
+)
 
 // BotMove returns the best column for the bot to play
-func BotMove(board *[game.Rows][game.Cols]int, botPlayer int) int {
+func BotMove(current *game.Game, botPlayer int) int {
+	cols := current.Rules.Cols
+	// try simulates dropping a disc for player into c on a copy of the game
+	try := func(c, player int) (*game.Game, int, error) {
+		g := current.CloneUntimed()
+		g.Turn = player
+		row, err := g.Drop(c, player)
+		return g, row, err
+	}
 	// 1. Try to win
-	for c := 0; c < game.Cols; c++ {
-		copy := *board
-		g := &game.Game{Board: copy}
-		row, err := g.Drop(c, botPlayer)
+	for c := 0; c < cols; c++ {
+		g, row, err := try(c, botPlayer)
 		if err == nil && g.CheckWin(row, c, botPlayer) {
 			return c
 		}
@@ -18,28 +27,21 @@ func BotMove(board *[game.Rows][game.Cols]int, botPlayer int) int {
 	if botPlayer == 1 {
 		opponent = 2
 	}
-	for c := 0; c < game.Cols; c++ {
-		copy := *board
-		g := &game.Game{Board: copy}
-		row, err := g.Drop(c, opponent)
+	for c := 0; c < cols; c++ {
+		g, row, err := try(c, opponent)
 		if err == nil && g.CheckWin(row, c, opponent) {
 			return c
 		}
 	}
 	// 3. Prefer center columns
-	order := []int{3, 2, 4, 1, 5, 0, 6}
-	for _, c := range order {
-		copy := *board
-		g := &game.Game{Board: copy}
-		if _, err := g.Drop(c, botPlayer); err == nil {
+	for _, c := range bot.CenterOrder(cols) {
+		if _, _, err := try(c, botPlayer); err == nil {
 			return c
 		}
 	}
 	// 4. Fallback: first available
-	for c := 0; c < game.Cols; c++ {
-		copy := *board
-		g := &game.Game{Board: copy}
-		if _, err := g.Drop(c, botPlayer); err == nil {
+	for c := 0; c < cols; c++ {
+		if _, _, err := try(c, botPlayer); err == nil {
 			return c
 		}
 	}
@@ -18,9 +18,9 @@ function render() {
   if (!gameState) return
   boardDiv.innerHTML = ''
   const table = document.createElement('table')
-  for (let r = 5; r >= 0; r--) {
+  for (let r = gameState.board.length - 1; r >= 0; r--) {
     const tr = document.createElement('tr')
-    for (let c = 0; c < 7; c++) {
+    for (let c = 0; c < gameState.board[r].length; c++) {
       const td = document.createElement('td')
       td.style.width = '40px'
       td.style.height = '40px'
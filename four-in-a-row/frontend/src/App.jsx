@@ -3,16 +3,47 @@ import styles from "./App.module.css";
 import AnimatedBackground from "./AnimatedBackground";
 import AnimatedDisc from "./AnimatedDisc";
 
-const COLS = 7;
-const ROWS = 6;
-
 export default function App() {
   const [username, setUsername] = useState("");
+  const [password, setPassword] = useState("");
+  // session from logging in; without one the player is a guest
+  const [auth, setAuth] = useState(() => {
+    const saved = JSON.parse(localStorage.getItem("auth") || "null");
+    return saved && new Date(saved.expires_at) > new Date() ? saved : null;
+  });
+  const [level, setLevel] = useState("medium");
+  const [timeControl, setTimeControl] = useState("");
+  const [receivedAt, setReceivedAt] = useState(0);
+  const [, setTick] = useState(0);
   const [connected, setConnected] = useState(false);
   const [game, setGame] = useState(null);
   const [error, setError] = useState("");
   const [leaderboard, setLeaderboard] = useState(null);
+  const [profile, setProfile] = useState(null);
+  const [hint, setHint] = useState(null);
+  const [you, setYou] = useState(0);
+  const [notice, setNotice] = useState("");
+  const [ratingChange, setRatingChange] = useState(null);
+  const [liveGames, setLiveGames] = useState(null);
+  const [watching, setWatching] = useState(null);
+  const [spectators, setSpectators] = useState(0);
+  const [board, setBoard] = useState("6x7");
+  const [first, setFirst] = useState("host");
+  const [roomCode, setRoomCode] = useState(
+    () => new URLSearchParams(window.location.search).get("room") || ""
+  );
+  const [room, setRoom] = useState(null);
+  const [searching, setSearching] = useState(false);
+  const [rematchBy, setRematchBy] = useState("");
+  const [series, setSeries] = useState(null);
   const wsRef = useRef(null);
+  const seqRef = useRef(0);
+  // the game as last applied, the seq of the last server event, and whether
+  // a resync has been asked for and not answered yet
+  const gameRef = useRef(null);
+  const lastSeqRef = useRef(0);
+  const resyncRef = useRef(false);
+  const receivedAtRef = useRef(0);
   const [animDrop, setAnimDrop] = useState({});
 
   // Cookie helpers
@@ -38,28 +69,219 @@ export default function App() {
 
   useEffect(() => {
     const saved = getCookie("username");
-    if (saved) setUsername(saved);
+    if (auth) setUsername(auth.username);
+    else if (saved) setUsername(saved);
   }, []);
 
-  function connect() {
+  // log in or register, kind being "login" or "register"
+  function authenticate(kind) {
+    setError("");
+    fetch(`/auth/${kind}`, {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify({ username, password }),
+    })
+      .then(async (r) => {
+        const data = await r.json();
+        if (!r.ok) {
+          setError(data.error || `${kind} failed`);
+          return;
+        }
+        localStorage.setItem("auth", JSON.stringify(data));
+        setAuth(data);
+        setUsername(data.username);
+        setPassword("");
+      })
+      .catch((err) => setError(`${kind} failed: ${err.message}`));
+  }
+
+  function logout() {
+    localStorage.removeItem("auth");
+    setAuth(null);
+  }
+
+  // Re-render while a clock is running so the display counts down
+  const clockRunning = game && game.clock && game.clock.running !== 0;
+  useEffect(() => {
+    if (!clockRunning) return;
+    const id = setInterval(() => setTick((t) => t + 1), 200);
+    return () => clearInterval(id);
+  }, [clockRunning]);
+
+  function timeLeft(player) {
+    const clock = game.clock;
+    let ms = clock.remaining_ms[player - 1];
+    if (clock.running === player) ms -= Date.now() - receivedAt;
+    ms = Math.max(0, ms);
+    const m = Math.floor(ms / 60000);
+    const s = Math.floor((ms % 60000) / 1000);
+    return `${m}:${String(s).padStart(2, "0")}`;
+  }
+
+  // the resume token of the game in progress, kept for the tab so a
+  // dropped connection or a reload can get back into it
+  function savedResume() {
+    const saved = JSON.parse(sessionStorage.getItem("resume") || "null");
+    return saved && saved.username === username ? saved : null;
+  }
+
+  // connect to play: matched with anyone, or with room "new" to open a
+  // private room, or a room's code to join it; or to watch a game from the
+  // live games list
+  function connect({ watch, room } = {}) {
     setError("");
-    setCookie("username", username, 30);
+    setSpectators(0);
+    setWatching(watch || null);
+    setRoom(null);
+    setSearching(false);
+    setSeries(null);
 
-    const ws = new WebSocket(
-      `ws://${window.location.hostname}:8080/ws?username=${encodeURIComponent(
+    let url;
+    let resume = null;
+    if (watch) {
+      setYou(0);
+      gameRef.current = null;
+      url = `ws://${window.location.hostname}:8080/ws?spectate=1&gameID=${encodeURIComponent(
+        watch.id
+      )}&protocol=1`;
+    } else {
+      setCookie("username", username, 30);
+      url = `ws://${window.location.hostname}:8080/ws?username=${encodeURIComponent(
         username
-      )}`
-    );
+      )}&level=${level}&time=${encodeURIComponent(timeControl)}&protocol=1`;
+      if (auth) url += `&token=${encodeURIComponent(auth.token)}`;
+      if (room === "new") {
+        const [rows, cols] = board.split("x");
+        url += `&room=new&rows=${rows}&cols=${cols}&first=${first}`;
+      } else if (room) {
+        url += `&room=${encodeURIComponent(room)}`;
+      } else {
+        resume = savedResume();
+      }
+    }
+    const token = resume && resume.token;
+    if (token) {
+      setYou(resume.you);
+      url += `&resume=${encodeURIComponent(token)}`;
+      // with the game still in hand only the missed events are needed
+      if (gameRef.current) url += `&last_seq=${lastSeqRef.current}`;
+    }
+    const ws = new WebSocket(url);
 
-    ws.onopen = () => setConnected(true);
-    ws.onclose = () => setConnected(false);
+    let opened = false;
+    ws.onopen = () => {
+      opened = true;
+      setConnected(true);
+    };
+    ws.onclose = () => {
+      setConnected(false);
+      setSearching(false);
+      if (!opened && token) {
+        sessionStorage.removeItem("resume");
+        setError("Could not resume the game. Connect again for a new one.");
+      } else if (!opened && watch) {
+        setError("That game is over or no longer exists.");
+      }
+    };
     ws.onmessage = (ev) => {
       try {
-        const data = JSON.parse(ev.data);
-        if (data.error) setError(data.error);
-        else {
-          setGame(data);
-          setAnimDrop({});
+        const { type, seq, payload } = JSON.parse(ev.data);
+        if (type === "matched" || type === "state") {
+          lastSeqRef.current = seq;
+          resyncRef.current = false;
+        } else if (resyncRef.current) {
+          return;
+        } else if (seq > lastSeqRef.current + 1) {
+          // an event went missing: ask for the whole game again
+          resync();
+          return;
+        } else {
+          lastSeqRef.current = seq;
+        }
+        switch (type) {
+          case "queued":
+            setSearching(true);
+            setNotice(
+              `Looking for an opponent (rating ${payload.rating})... about ${Math.ceil(
+                payload.estimated_wait_ms / 1000
+              )}s.`
+            );
+            break;
+          case "room":
+            setRoom(payload);
+            break;
+          case "matched":
+            setRoom(null);
+            setSearching(false);
+            setRematchBy("");
+            setYou(payload.you);
+            setRatingChange(null);
+            sessionStorage.setItem(
+              "resume",
+              JSON.stringify({
+                username,
+                you: payload.you,
+                token: payload.resume_token,
+              })
+            );
+            showGame(payload.game);
+            break;
+          case "state":
+            showGame(payload);
+            break;
+          case "move":
+            applyMove(payload);
+            break;
+          case "game_over":
+            sessionStorage.removeItem("resume");
+            applyGameOver(payload);
+            break;
+          case "clock":
+            if (gameRef.current) {
+              gameRef.current = { ...gameRef.current, clock: payload };
+              setGame(gameRef.current);
+              receivedAtRef.current = Date.now();
+              setReceivedAt(receivedAtRef.current);
+            }
+            break;
+          case "hint":
+            setHint(payload);
+            break;
+          case "rating":
+            setRatingChange(payload.find((c) => c.username === username));
+            break;
+          case "rematch_offer":
+            setRematchBy(payload.by);
+            break;
+          case "rematch_declined":
+            setRematchBy("");
+            setSeries(null);
+            setNotice(
+              payload.reason === "timeout"
+                ? "No rematch in time."
+                : `${payload.by} ${
+                    payload.reason === "left" ? "left" : "declined the rematch"
+                  }.`
+            );
+            break;
+          case "series":
+            setSeries(payload);
+            break;
+          case "spectators":
+            setSpectators(payload.count);
+            break;
+          case "error":
+            setError(payload.message);
+            break;
+          case "opponent_disconnected":
+            setNotice(
+              `${payload.username} disconnected and has ${Math.round(
+                payload.grace_ms / 1000
+              )}s to come back.`
+            );
+            break;
+          default:
+            console.warn("Unknown message type:", type);
         }
       } catch (e) {
         console.error("WebSocket parse error:", e);
@@ -69,10 +291,75 @@ export default function App() {
     wsRef.current = ws;
   }
 
+  function showGame(g) {
+    gameRef.current = g;
+    setGame(g);
+    receivedAtRef.current = Date.now();
+    setReceivedAt(receivedAtRef.current);
+    setAnimDrop({});
+    setHint(null);
+    setNotice("");
+  }
+
+  function resync() {
+    resyncRef.current = true;
+    sendMessage("resync");
+  }
+
+  // apply a move event to the game we have, resyncing if it does not follow
+  function applyMove(m) {
+    const g = gameRef.current;
+    if (!g || m.move !== g.moves.length + 1) {
+      resync();
+      return;
+    }
+    const board = g.board.map((r) => r.slice());
+    board[m.row][m.column] = m.player;
+    const move = { player: m.player, column: m.column, row: m.row };
+    showGame({
+      ...g,
+      board,
+      moves: [...g.moves, move],
+      turn: 3 - m.player,
+      offer: undefined,
+    });
+  }
+
+  function applyGameOver(over) {
+    const g = gameRef.current;
+    if (!g) {
+      resync();
+      return;
+    }
+    let clock = g.clock;
+    if (clock && clock.running !== 0) {
+      // freeze the clock where it stopped
+      const remaining_ms = clock.remaining_ms.slice();
+      remaining_ms[clock.running - 1] -= Date.now() - receivedAtRef.current;
+      clock = { ...clock, remaining_ms, running: 0 };
+    }
+    showGame({
+      ...g,
+      finished: true,
+      winner: over.winner,
+      reason: over.reason,
+      offer: undefined,
+      clock,
+    });
+  }
+
+  // send a typed message in the protocol version 1 envelope
+  function sendMessage(type, payload) {
+    seqRef.current += 1;
+    wsRef.current.send(
+      JSON.stringify({ type, seq: seqRef.current, protocol_version: 1, payload })
+    );
+  }
+
   function drop(col) {
-    if (!wsRef.current || !game || game.finished) return;
+    if (!wsRef.current || !game || game.finished || watching) return;
 
-    wsRef.current.send(JSON.stringify({ action: "drop", column: col }));
+    sendMessage("drop", { column: col });
 
     // Animate disc drop
     const row = game.board.findIndex((r) => r[col] === 0);
@@ -82,6 +369,36 @@ export default function App() {
     }
   }
 
+  function askHint() {
+    send("hint");
+  }
+
+  // send an action without a column: hint, resign, draw and takeback offers
+  function send(action) {
+    if (!wsRef.current || !game || game.finished) return;
+    sendMessage(action);
+  }
+
+  function fetchProfile(name) {
+    fetch(`/players/${encodeURIComponent(name)}`)
+      .then(async (r) => {
+        const data = await r.json();
+        if (!r.ok) setError(data.error || "Profile fetch failed");
+        else setProfile(data);
+      })
+      .catch((err) => setError("Profile fetch error: " + err.message));
+  }
+
+  function fetchLiveGames() {
+    fetch("/games/live")
+      .then(async (r) => {
+        const data = await r.json();
+        if (!r.ok) setError(data.error || "Live games fetch failed");
+        else setLiveGames(data);
+      })
+      .catch((err) => setError("Live games fetch error: " + err.message));
+  }
+
   function fetchLeaderboard() {
     fetch("/leaderboard")
       .then(async (r) => {
@@ -119,12 +436,71 @@ export default function App() {
             placeholder="Enter username"
             value={username}
             onChange={(e) => setUsername(e.target.value)}
-            disabled={connected}
+            disabled={connected || auth}
           />
+          {!auth ? (
+            <>
+              <input
+                className={styles.input}
+                type="password"
+                placeholder="Password (optional)"
+                value={password}
+                onChange={(e) => setPassword(e.target.value)}
+                disabled={connected}
+              />
+              <button
+                className={styles.button}
+                onClick={() => authenticate("login")}
+                disabled={connected || !username || !password}
+              >
+                Log in
+              </button>
+              <button
+                className={styles.button}
+                onClick={() => authenticate("register")}
+                disabled={connected || !username || !password}
+              >
+                Register
+              </button>
+            </>
+          ) : (
+            <button
+              className={styles.button}
+              onClick={logout}
+              disabled={connected}
+            >
+              Log out
+            </button>
+          )}
+          <select
+            className={styles.input}
+            value={level}
+            onChange={(e) => setLevel(e.target.value)}
+            disabled={connected}
+            title="Bot to play if no opponent is found"
+          >
+            <option value="easy">Easy bot</option>
+            <option value="medium">Medium bot</option>
+            <option value="hard">Hard bot</option>
+            <option value="perfect">Perfect bot</option>
+            <option value="mcts">Monte Carlo bot</option>
+          </select>
+          <select
+            className={styles.input}
+            value={timeControl}
+            onChange={(e) => setTimeControl(e.target.value)}
+            disabled={connected}
+            title="Time control"
+          >
+            <option value="">Untimed</option>
+            <option value="1m+0s">1 minute</option>
+            <option value="3m+2s">3 min + 2 s</option>
+            <option value="30s/move">30 s per move</option>
+          </select>
           {!connected ? (
             <button
               className={styles.button}
-              onClick={connect}
+              onClick={() => connect()}
               disabled={!username}
             >
               Connect
@@ -139,13 +515,92 @@ export default function App() {
           )}
         </div>
 
+        {/* Private rooms */}
+        <div className={styles.usernameBar}>
+          <select
+            className={styles.input}
+            value={board}
+            onChange={(e) => setBoard(e.target.value)}
+            disabled={connected}
+            title="Board size of a new room"
+          >
+            <option value="6x7">6 x 7</option>
+            <option value="7x8">7 x 8</option>
+            <option value="8x9">8 x 9</option>
+          </select>
+          <select
+            className={styles.input}
+            value={first}
+            onChange={(e) => setFirst(e.target.value)}
+            disabled={connected}
+            title="Who moves first in a new room"
+          >
+            <option value="host">I move first</option>
+            <option value="guest">Friend moves first</option>
+            <option value="random">Random first move</option>
+          </select>
+          <button
+            className={styles.button}
+            onClick={() => connect({ room: "new" })}
+            disabled={connected || !username}
+          >
+            Create room
+          </button>
+          <input
+            className={styles.input}
+            placeholder="Room code"
+            value={roomCode}
+            onChange={(e) => setRoomCode(e.target.value.toUpperCase())}
+            disabled={connected}
+          />
+          <button
+            className={styles.button}
+            onClick={() => connect({ room: roomCode })}
+            disabled={connected || !username || !roomCode}
+          >
+            Join room
+          </button>
+        </div>
+        {room && connected && (
+          <div className={styles.status}>
+            Room <b>{room.code}</b>: send your friend{" "}
+            <a href={`${window.location.origin}/?room=${room.code}`}>
+              {`${window.location.origin}/?room=${room.code}`}
+            </a>
+            . Open until {new Date(room.expires_at).toLocaleTimeString()}.{" "}
+            <button className={styles.button} onClick={() => sendMessage("cancel")}>
+              Cancel
+            </button>
+          </div>
+        )}
+
         {/* Error Message */}
         {error && <div className={styles.error}>{error}</div>}
 
         {/* Connection Status */}
-        {connected && (
+        {connected && watching && (
+          <div className={styles.status}>
+            Watching <b>{watching.players.join(" vs ")}</b>
+          </div>
+        )}
+        {connected && !watching && (
           <div className={styles.status}>
             Connected as <b>{username}</b>
+            {!auth && " (guest, unranked)"}
+            {you !== 0 && <> ({you === 1 ? "Red" : "Yellow"})</>}
+          </div>
+        )}
+        {notice && (
+          <div className={styles.status}>
+            {notice}
+            {searching && connected && (
+              <>
+                {" "}
+                <button className={styles.button} onClick={() => sendMessage("cancel")}>
+                  Cancel
+                </button>
+              </>
+            )}
           </div>
         )}
 
@@ -154,14 +609,26 @@ export default function App() {
           <>
             <div className={styles.gameInfo}>
               Game ID: <b>{game.id}</b>
+              {spectators > 0 && <> · {spectators} watching</>}
             </div>
-            <div className={styles.board}>
+            {game.clock && (
+              <div className={styles.gameInfo}>
+                Red <b>{timeLeft(1)}</b> · Yellow <b>{timeLeft(2)}</b>
+              </div>
+            )}
+            <div
+              className={styles.board}
+              style={{
+                gridTemplateColumns: `repeat(${game.rules.cols}, var(--cell-size))`,
+                gridTemplateRows: `repeat(${game.rules.rows}, var(--cell-size))`,
+              }}
+            >
               {game.board
                 .slice()
                 .reverse()
                 .map((row, rIdx) =>
                   row.map((cell, cIdx) => {
-                    const boardRow = ROWS - 1 - rIdx;
+                    const boardRow = game.rules.rows - 1 - rIdx;
                     const isAnim =
                       animDrop.col === cIdx && animDrop.row === boardRow;
 
@@ -170,7 +637,7 @@ export default function App() {
                         key={`${rIdx}-${cIdx}`}
                         className={styles.cell}
                         onClick={() =>
-                          !game.finished && cell === 0 && drop(cIdx)
+                          !game.finished && !watching && cell === 0 && drop(cIdx)
                         }
                       >
                         {cell !== 0 && (
@@ -182,6 +649,59 @@ export default function App() {
                 )}
             </div>
 
+            {!game.finished && !watching && (
+              <div className={styles.status}>
+                <button className={styles.button} onClick={askHint}>
+                  Hint
+                </button>{" "}
+                <button className={styles.button} onClick={() => send("offer_draw")}>
+                  Offer draw
+                </button>{" "}
+                <button
+                  className={styles.button}
+                  onClick={() => send("request_takeback")}
+                >
+                  Takeback
+                </button>{" "}
+                <button className={styles.button} onClick={() => send("resign")}>
+                  Resign
+                </button>
+                {hint && (
+                  <span>
+                    {" "}
+                    Try column <b>{hint.column + 1}</b> (
+                    {hint.reason.replace("_", " ")}), {hint.hints_left} left.
+                    Games with hints are unranked.
+                  </span>
+                )}
+              </div>
+            )}
+
+            {game.offer && !game.finished && (
+              <div className={styles.status}>
+                {game.offer.by === 1 ? "Red" : "Yellow"} offers a{" "}
+                {game.offer.kind === "draw" ? "draw" : "takeback"}.{" "}
+                {watching ? null : game.offer.by === you ? (
+                  "Waiting for an answer..."
+                ) : (
+                  <>
+                    <button
+                      className={styles.button}
+                      onClick={() => send(`accept_${game.offer.kind}`)}
+                    >
+                      Accept
+                    </button>{" "}
+                    <button
+                      className={styles.button}
+                      onClick={() => send(`decline_${game.offer.kind}`)}
+                    >
+                      Decline
+                    </button>
+                  </>
+                )}
+              </div>
+            )}
+
             {/* Game Status */}
             {game.finished && (
               <div className={styles.status}>
@@ -190,11 +710,90 @@ export default function App() {
                   : game.winner === 1
                   ? "Red wins!"
                   : "Yellow wins!"}
+                {game.reason === "timeout" && " (on time)"}
+                {game.reason === "resigned" && " (resignation)"}
+                {game.reason === "draw_agreed" && " (agreed)"}
+                {ratingChange && (
+                  <div>
+                    Rating {ratingChange.before} → <b>{ratingChange.after}</b>
+                    {ratingChange.provisional && " (provisional)"}
+                  </div>
+                )}
+                {series && (
+                  <div>
+                    Series: {series.players[0]} <b>{series.wins[0]}</b> –{" "}
+                    <b>{series.wins[1]}</b> {series.players[1]}
+                    {series.draws > 0 && ` (${series.draws} drawn)`}
+                  </div>
+                )}
+                {connected && !watching && series && (
+                  <div>
+                    {rematchBy === username ? (
+                      "Waiting for an answer to your rematch offer..."
+                    ) : (
+                      <>
+                        {rematchBy && `${rematchBy} wants a rematch. `}
+                        <button
+                          className={styles.button}
+                          onClick={() => sendMessage("rematch")}
+                        >
+                          {rematchBy ? "Accept rematch" : "Rematch"}
+                        </button>{" "}
+                        <button
+                          className={styles.button}
+                          onClick={() => sendMessage("decline_rematch")}
+                        >
+                          {rematchBy ? "Decline" : "New opponent"}
+                        </button>
+                      </>
+                    )}
+                  </div>
+                )}
               </div>
             )}
           </>
         )}
 
+        {/* Games to watch */}
+        <div className={styles.leaderboard}>
+          <button className={styles.button} onClick={fetchLiveGames}>
+            Watch a Game
+          </button>
+          {liveGames && liveGames.length === 0 && (
+            <div className={styles.status}>No games in progress.</div>
+          )}
+          {liveGames && liveGames.length > 0 && (
+            <table className={styles.leaderboardTable}>
+              <thead>
+                <tr>
+                  <th>Players</th>
+                  <th>Moves</th>
+                  <th>Watching</th>
+                  <th></th>
+                </tr>
+              </thead>
+              <tbody>
+                {liveGames.map((g) => (
+                  <tr key={g.id}>
+                    <td>{g.players.join(" vs ")}</td>
+                    <td>{g.moves}</td>
+                    <td>{g.spectators}</td>
+                    <td>
+                      <button
+                        className={styles.button}
+                        onClick={() => connect({ watch: g })}
+                        disabled={connected}
+                      >
+                        Watch
+                      </button>
+                    </td>
+                  </tr>
+                ))}
+              </tbody>
+            </table>
+          )}
+        </div>
+
         {/* Leaderboard */}
         <div className={styles.leaderboard}>
           <button className={styles.button} onClick={fetchLeaderboard}>
@@ -206,6 +805,7 @@ export default function App() {
                 <tr>
                   <th>Rank</th>
                   <th>Player</th>
+                  <th>Rating</th>
                   <th>Wins</th>
                 </tr>
               </thead>
@@ -213,13 +813,32 @@ export default function App() {
                 {leaderboard.map((entry, idx) => (
                   <tr key={entry.username}>
                     <td>{idx + 1}</td>
-                    <td>{entry.username}</td>
+                    <td>
+                      <a href="#" onClick={() => fetchProfile(entry.username)}>
+                        {entry.username}
+                      </a>
+                    </td>
+                    <td title={entry.provisional ? "Provisional" : undefined}>
+                      {entry.rating}
+                      {entry.provisional && "?"}
+                    </td>
                     <td>{entry.wins}</td>
                   </tr>
                 ))}
               </tbody>
             </table>
           )}
+          {profile && (
+            <div className={styles.status}>
+              <b>{profile.username}</b>: {profile.stats.played} games,{" "}
+              {profile.stats.wins}W {profile.stats.losses}L{" "}
+              {profile.stats.draws}D, {profile.stats.forfeits} forfeits. Streak{" "}
+              {profile.stats.current_streak} (best {profile.stats.best_streak}).
+              Against the bot {profile.stats.vs_bot.wins}W{" "}
+              {profile.stats.vs_bot.losses}L {profile.stats.vs_bot.draws}D.
+              Average game {Math.round(profile.stats.avg_moves)} moves.
+            </div>
+          )}
         </div>
       </div>
     </>
@@ -2,6 +2,11 @@ export default {
   server: {
     proxy: {
       '/leaderboard': 'http://localhost:8080',
+      '/auth': 'http://localhost:8080',
+      '/players': 'http://localhost:8080',
+      '/games': 'http://localhost:8080',
+      '/rooms': 'http://localhost:8080',
+      '/matchmaking': 'http://localhost:8080',
       '/ws': {
         target: 'ws://localhost:8080',
         ws: true